@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Page sizes for the v1 api
+const defaultAPIPageLimit = 50
+const maxAPIPageLimit = 500
+
+// APIPage is the envelope every v1 list comes back in
+type APIPage struct {
+	// Total is how many items there are before limit and offset
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+	Items  interface{} `json:"items"`
+}
+
+// APIPeriod is the span of time a v1 response covers
+type APIPeriod struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// APIAthlete is the public part of a registered user, never their tokens
+type APIAthlete struct {
+	AthleteID            int    `json:"athlete_id"`
+	AthleteFirstName     string `json:"athlete_firstname"`
+	AthleteProfileMedium string `json:"athlete_profile_medium,omitempty"`
+	// GoalMiles is their goal for the challenge, 0 without one
+	GoalMiles float32 `json:"goal_miles,omitempty"`
+}
+
+// APILeaderboardEntry is an athlete's place and numbers over a period. It's the same for the whole
+// challenge and for shorter periods, v1 clients rely on that so changes to it need a new api version
+type APILeaderboardEntry struct {
+	Place                int           `json:"place"`
+	AthleteID            int           `json:"athlete_id"`
+	AthleteFirstName     string        `json:"athlete_firstname"`
+	AthleteProfileMedium string        `json:"athlete_profile_medium,omitempty"`
+	Counts               AthleteCounts `json:"counts"`
+	// Goal is progress towards their challenge goal. Only the whole challenge has it, and only for athletes with a goal
+	Goal        *GoalProgress `json:"goal,omitempty"`
+	Stale       bool          `json:"stale,omitempty"`
+	StaleReason string        `json:"stale_reason,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// APIAthleteSummary is one athlete's leaderboard entry for the period
+type APIAthleteSummary struct {
+	Period APIPeriod           `json:"period"`
+	Report APILeaderboardEntry `json:"report"`
+}
+
+// APIDailyMiles is an athlete's challenge miles for every day they have any, by category
+type APIDailyMiles struct {
+	AthleteID        int            `json:"athlete_id"`
+	AthleteFirstName string         `json:"athlete_firstname"`
+	Days             []APIDayCounts `json:"days"`
+}
+
+type APIDayCounts struct {
+	// Date is the local day, YYYY-MM-DD
+	Date   string        `json:"date"`
+	Counts AthleteCounts `json:"counts"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// apiPage reads `limit` and `offset`
+func apiPage(r *http.Request) (int, int, error) {
+	limit, offset := defaultAPIPageLimit, 0
+	var err error
+	if text := r.URL.Query().Get("limit"); text != "" {
+		limit, err = strconv.Atoi(text)
+		if err != nil || limit < 1 || limit > maxAPIPageLimit {
+			return 0, 0, errors.New("limit must be 1 to " + strconv.Itoa(maxAPIPageLimit))
+		}
+	}
+	if text := r.URL.Query().Get("offset"); text != "" {
+		offset, err = strconv.Atoi(text)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be 0 or more")
+		}
+	}
+	return limit, offset, nil
+}
+
+// pageBounds is the [start, end) slice of total items the page covers
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+// apiPeriod reads `period`, anything ParsePeriod understands. Without one (or `year`) it's the whole challenge.
+// ok is false for `year`
+func apiPeriod(r *http.Request, challenge Challenge) (Period, bool, error) {
+	text := r.URL.Query().Get("period")
+	if text == "" || strings.EqualFold(text, "year") {
+		return Period{Name: "year", Window: challenge.Window()}, false, nil
+	}
+	period, err := ParsePeriod(text, challenge.Now())
+	if err != nil {
+		return period, false, err
+	}
+	period, inChallenge := period.clampTo(challenge)
+	if !inChallenge {
+		return period, false, errors.New("Period " + html.EscapeString(text) + " is outside of the challenge")
+	}
+	return period, true, nil
+}
+
+func (p Period) api() APIPeriod {
+	return APIPeriod{Name: p.Name, Start: p.Window.Start, End: p.Window.End}
+}
+
+// apiAthleteID reads the {id} path variable
+func apiAthleteID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, errors.New("Invalid athlete id")
+	}
+	return id, nil
+}
+
+// RegisterAPIRoutes adds the read only v1 json api under /api/v1. Every endpoint takes `year` to pick a challenge
+func RegisterAPIRoutes(rtr *mux.Router) {
+	v1 := rtr.PathPrefix("/api/v1").Subrouter()
+	v1.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
+			next.ServeHTTP(w, r)
+		})
+	})
+	v1.HandleFunc("/leaderboard", apiLeaderboardHandler).Methods("GET")
+	v1.HandleFunc("/athletes", apiAthletesHandler).Methods("GET")
+	v1.HandleFunc("/athletes/{id:[0-9]+}/activities", apiAthleteActivitiesHandler).Methods("GET")
+	v1.HandleFunc("/athletes/{id:[0-9]+}/summary", apiAthleteSummaryHandler).Methods("GET")
+	v1.HandleFunc("/daily-miles", apiDailyMilesHandler).Methods("GET")
+}
+
+// apiLeaderboard is everyone's entry for the period, most miles first. isPeriod is false for the whole challenge
+func apiLeaderboard(ctx context.Context, challenge Challenge, period Period, isPeriod bool) []APILeaderboardEntry {
+	entries := []APILeaderboardEntry{}
+	if isPeriod {
+		for i, report := range GeneratePeriodReport(ctx, challenge, period) {
+			entries = append(entries, APILeaderboardEntry{
+				Place:                i + 1,
+				AthleteID:            report.AthleteID,
+				AthleteFirstName:     report.AthleteFirstName,
+				AthleteProfileMedium: report.AthleteProfileMedium,
+				Counts:               report.Counts,
+				Stale:                report.Stale,
+				StaleReason:          report.StaleReason,
+				Error:                report.Error,
+			})
+		}
+		return entries
+	}
+	for i, report := range GenerateReportForChallenge(ctx, challenge) {
+		entries = append(entries, APILeaderboardEntry{
+			Place:                i + 1,
+			AthleteID:            report.AthleteID,
+			AthleteFirstName:     report.AthleteFirstName,
+			AthleteProfileMedium: report.AthleteProfileMedium,
+			Counts:               report.YearToDate,
+			Goal:                 report.Goal,
+			Stale:                report.Stale,
+			StaleReason:          report.StaleReason,
+			Error:                report.Error,
+		})
+	}
+	return entries
+}
+
+// apiLeaderboardHandler is everyone's numbers, most miles first. `period` picks the span, it's the whole challenge by default
+func apiLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := challengeFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	period, isPeriod, err := apiPeriod(r, challenge)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, offset, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	entries := apiLeaderboard(r.Context(), challenge, period, isPeriod)
+	start, end := pageBounds(len(entries), limit, offset)
+	writeAPIJSON(w, http.StatusOK, struct {
+		Period APIPeriod `json:"period"`
+		APIPage
+	}{period.api(), APIPage{Total: len(entries), Limit: limit, Offset: offset, Items: entries[start:end]}})
+}
+
+// apiAthletesHandler lists the registered athletes by first name. `name` filters to names containing it
+func apiAthletesHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := challengeFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, offset, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	users, err := ReadUserCredentials()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errors.New("Failed to read athletes"))
+		return
+	}
+	name := strings.ToLower(r.URL.Query().Get("name"))
+	athletes := []APIAthlete{}
+	for _, user := range users {
+		if !strings.Contains(strings.ToLower(user.Athlete.Firstname), name) {
+			continue
+		}
+		athletes = append(athletes, APIAthlete{
+			AthleteID:            user.Athlete.ID,
+			AthleteFirstName:     user.Athlete.Firstname,
+			AthleteProfileMedium: user.Athlete.ProfileMedium,
+			GoalMiles:            user.Goals[challenge.Year],
+		})
+	}
+	sort.SliceStable(athletes, func(i, j int) bool {
+		return strings.ToLower(athletes[i].AthleteFirstName) < strings.ToLower(athletes[j].AthleteFirstName)
+	})
+	start, end := pageBounds(len(athletes), limit, offset)
+	writeAPIJSON(w, http.StatusOK, APIPage{Total: len(athletes), Limit: limit, Offset: offset, Items: athletes[start:end]})
+}
+
+// apiAthleteActivitiesHandler is one athlete's activities from every source, newest first.
+// `category` and `source` filter them
+func apiAthleteActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := apiAthleteID(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	challenge, err := challengeFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	period, _, err := apiPeriod(r, challenge)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, offset, err := apiPage(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, found, err := FindUserCredentials(athleteID); err != nil || !found {
+		writeAPIError(w, http.StatusNotFound, errors.New("No athlete with id "+strconv.Itoa(athleteID)))
+		return
+	}
+	category := strings.ToLower(r.URL.Query().Get("category"))
+	source := strings.ToLower(r.URL.Query().Get("source"))
+	activities := []Activity{}
+	for _, athletes := range FetchFromAllSources(r.Context(), period.Window) {
+		for _, athlete := range athletes {
+			if athlete.AthleteID != athleteID {
+				continue
+			}
+			for _, activity := range athlete.Activities {
+				if !period.Contains(activity) || (category != "" && activity.Category != category) || (source != "" && activity.Source != source) {
+					continue
+				}
+				activities = append(activities, activity)
+			}
+		}
+	}
+	sort.SliceStable(activities, func(i, j int) bool { return activities[i].Date.After(activities[j].Date) })
+	start, end := pageBounds(len(activities), limit, offset)
+	writeAPIJSON(w, http.StatusOK, struct {
+		Period APIPeriod `json:"period"`
+		APIPage
+	}{period.api(), APIPage{Total: len(activities), Limit: limit, Offset: offset, Items: activities[start:end]}})
+}
+
+// apiAthleteSummaryHandler is one athlete's leaderboard entry for the period
+func apiAthleteSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := apiAthleteID(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	challenge, err := challengeFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	period, isPeriod, err := apiPeriod(r, challenge)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, entry := range apiLeaderboard(r.Context(), challenge, period, isPeriod) {
+		if entry.AthleteID == athleteID {
+			writeAPIJSON(w, http.StatusOK, APIAthleteSummary{Period: period.api(), Report: entry})
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, errors.New("No athlete with id "+strconv.Itoa(athleteID)))
+}
+
+// apiDailyMilesHandler is every athlete's miles day by day over the period, oldest first, for charts
+func apiDailyMilesHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := challengeFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	period, _, err := apiPeriod(r, challenge)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	athletes := []APIDailyMiles{}
+	indexByID := map[int]int{}
+	days := map[int]map[string]*AthleteCounts{}
+	for _, results := range FetchFromAllSources(r.Context(), period.Window) {
+		for _, athlete := range results {
+			i, exists := indexByID[athlete.AthleteID]
+			if !exists {
+				athletes = append(athletes, APIDailyMiles{AthleteID: athlete.AthleteID, AthleteFirstName: athlete.AthleteFirstName, Days: []APIDayCounts{}})
+				i = len(athletes) - 1
+				indexByID[athlete.AthleteID] = i
+				days[athlete.AthleteID] = map[string]*AthleteCounts{}
+			}
+			for _, activity := range athlete.Activities {
+				if !period.Contains(activity) {
+					continue
+				}
+				date := activity.Date.Format("2006-01-02")
+				if days[athlete.AthleteID][date] == nil {
+					days[athlete.AthleteID][date] = &AthleteCounts{}
+				}
+				days[athlete.AthleteID][date].add(activity)
+			}
+		}
+	}
+	for i := range athletes {
+		for date, counts := range days[athletes[i].AthleteID] {
+			athletes[i].Days = append(athletes[i].Days, APIDayCounts{Date: date, Counts: *counts})
+		}
+		sort.Slice(athletes[i].Days, func(a, b int) bool { return athletes[i].Days[a].Date < athletes[i].Days[b].Date })
+	}
+	writeAPIJSON(w, http.StatusOK, struct {
+		Period   APIPeriod       `json:"period"`
+		Athletes []APIDailyMiles `json:"athletes"`
+	}{period.api(), athletes})
+}
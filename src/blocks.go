@@ -0,0 +1,100 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bclouser/miles-challenge/slack"
+)
+
+// Each athlete takes at most this many blocks, the title and footer take the rest
+const blocksPerAthlete = 4
+
+func placeText(place int, name string) string {
+	medal := ""
+	switch place {
+	case 1:
+		medal = " :first_place_medal:"
+	case 2:
+		medal = " :second_place_medal:"
+	case 3:
+		medal = " :third_place_medal:"
+	}
+	return "*" + numberToPlaceStr(place) + "*  " + name + medal
+}
+
+// athleteBlocks lays out one athlete: name and avatar, then today vs the year side by side
+func athleteBlocks(place int, athlete UserReport) []slack.Block {
+	summary := placeText(place, athlete.AthleteFirstName) + "\nTotal Challenge Miles: *" + floatStr(athlete.YearToDate.Total()) + "*"
+	if athlete.Goal != nil {
+		summary += "\n" + formatGoal(*athlete.Goal)
+	}
+	blocks := []slack.Block{
+		slack.Section(summary).
+			WithImage(athlete.AthleteProfileMedium, athlete.AthleteFirstName),
+		slack.SectionFields(
+			"*Today*\nRun: "+floatStr(athlete.Day.RunMiles)+"\nHiked: "+floatStr(athlete.Day.HikeMiles)+"\nLifted: "+floatStr(athlete.Day.LiftMiles),
+			"*This Year*\nRun: "+floatStr(athlete.YearToDate.RunMiles)+"\nHiked: "+floatStr(athlete.YearToDate.HikeMiles)+"\nLifted: "+floatStr(athlete.YearToDate.LiftMiles),
+		),
+	}
+	if athlete.Stale || athlete.Error != "" {
+		blocks = append(blocks, staleBlock(athlete.StaleReason, athlete.Error))
+	}
+	return append(blocks, slack.Divider())
+}
+
+func staleBlock(reason, errText string) slack.Block {
+	if errText != "" {
+		return slack.Context(slack.MarkdownElement(":x: Couldn't get this athlete's numbers: " + errText))
+	}
+	return slack.Context(slack.MarkdownElement(":warning: Numbers may be behind: " + reason))
+}
+
+func reportFooter() slack.Block {
+	return slack.Context(slack.MarkdownElement("Lifted miles are converted from exercise minutes. Updated " + time.Now().In(CurrentChallenge().Location()).Format("Jan 2 3:04 PM MST")))
+}
+
+// renderReportBlocks is the Block Kit version of formatReports
+func renderReportBlocks(title string, athleteReports []UserReport) []slack.Block {
+	blocks := []slack.Block{slack.Header(title)}
+	for i, athlete := range athleteReports {
+		if len(blocks)+blocksPerAthlete+2 > slack.MaxBlocks {
+			blocks = append(blocks, slack.Context(slack.MarkdownElement("...and more athletes that didn't fit")))
+			break
+		}
+		blocks = append(blocks, athleteBlocks(i+1, athlete)...)
+	}
+	return append(blocks, reportFooter())
+}
+
+// renderAthleteBlocks shows a handful of athletes with their overall place, for `me`, `athlete` and `compare`
+func renderAthleteBlocks(summary string, places []int, athletes []UserReport) []slack.Block {
+	blocks := []slack.Block{}
+	if summary != "" {
+		blocks = append(blocks, slack.Section(summary))
+	}
+	for i := range athletes {
+		blocks = append(blocks, athleteBlocks(places[i], athletes[i])...)
+	}
+	return append(blocks, reportFooter())
+}
+
+// renderPeriodBlocks is the Block Kit version of formatPeriodReports
+func renderPeriodBlocks(title string, reports []PeriodReport) []slack.Block {
+	blocks := []slack.Block{slack.Header(title)}
+	for i, athlete := range reports {
+		if len(blocks)+3+2 > slack.MaxBlocks {
+			blocks = append(blocks, slack.Context(slack.MarkdownElement("...and more athletes that didn't fit")))
+			break
+		}
+		blocks = append(blocks,
+			slack.Section(placeText(i+1, athlete.AthleteFirstName)+"\nTotal Challenge Miles: *"+floatStr(athlete.Counts.Total())+"*\n"+
+				"Run: "+floatStr(athlete.Counts.RunMiles)+"   Hiked: "+floatStr(athlete.Counts.HikeMiles)+"   Lifted: "+floatStr(athlete.Counts.LiftMiles)).
+				WithImage(athlete.AthleteProfileMedium, athlete.AthleteFirstName),
+		)
+		if athlete.Stale || athlete.Error != "" {
+			blocks = append(blocks, staleBlock(athlete.StaleReason, athlete.Error))
+		}
+		blocks = append(blocks, slack.Divider())
+	}
+	return append(blocks, reportFooter())
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const activityCacheDirName = "activity_cache"
+
+// athleteCache is everything we have stored locally for one athlete
+type athleteCache struct {
+	AthleteID int `json:"athlete_id"`
+	// Synced are the spans of time we have pulled every activity for, oldest first and never touching
+	Synced []syncedRange `json:"synced"`
+	// SyncedFrom is from caches written before Synced, it meant everything from then up to LastSync
+	SyncedFrom time.Time `json:"synced_from,omitempty"`
+	LastSync   time.Time `json:"last_sync"`
+	// ThrottledAt is when a sync last failed because of strava's rate limit. Cleared by a successful sync
+	ThrottledAt time.Time `json:"throttled_at"`
+	// Keyed by SummaryActivity.ID
+	Activities map[int64]SummaryActivity `json:"activities"`
+}
+
+type syncedRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// addSynced adds the range, joining it up with any it overlaps or touches
+func (cached *athleteCache) addSynced(added syncedRange) {
+	ranges := []syncedRange{}
+	for _, r := range cached.Synced {
+		if r.End.Before(added.Start) || added.End.Before(r.Start) {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.Start.Before(added.Start) {
+			added.Start = r.Start
+		}
+		if r.End.After(added.End) {
+			added.End = r.End
+		}
+	}
+	ranges = append(ranges, added)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start.Before(ranges[j].Start) })
+	cached.Synced = ranges
+}
+
+// ActivityCache keeps every athlete's strava activities on disk in the non-volatile storage dir
+// so reports don't have to go to strava. One json file per athlete
+type ActivityCache struct {
+	mutex    sync.Mutex
+	dir      string
+	athletes map[int]*athleteCache
+}
+
+var activityCache *ActivityCache
+
+func NewActivityCache(dir string) (*ActivityCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &ActivityCache{dir: dir, athletes: map[int]*athleteCache{}}, nil
+}
+
+func (c *ActivityCache) athletePath(athleteID int) string {
+	return filepath.Join(c.dir, strconv.Itoa(athleteID)+".json")
+}
+
+// load returns the cached athlete, reading it from disk the first time. Caller must hold the mutex
+func (c *ActivityCache) load(athleteID int) (*athleteCache, error) {
+	if cached, ok := c.athletes[athleteID]; ok {
+		return cached, nil
+	}
+	cached := &athleteCache{AthleteID: athleteID, Activities: map[int64]SummaryActivity{}}
+	data, err := ioutil.ReadFile(c.athletePath(athleteID))
+	if err == nil {
+		err = json.Unmarshal(data, cached)
+		if err != nil {
+			return nil, err
+		}
+		if cached.Activities == nil {
+			cached.Activities = map[int64]SummaryActivity{}
+		}
+		if !cached.SyncedFrom.IsZero() && len(cached.Synced) == 0 && cached.LastSync.After(cached.SyncedFrom) {
+			cached.Synced = []syncedRange{{Start: cached.SyncedFrom, End: cached.LastSync}}
+		}
+		cached.SyncedFrom = time.Time{}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	c.athletes[athleteID] = cached
+	return cached, nil
+}
+
+// save writes to a temp file and renames it so a crash never leaves a half written cache. Caller must hold the mutex
+func (c *ActivityCache) save(cached *athleteCache) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.athletePath(cached.AthleteID), data, 0644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Covers tells us if the cache holds every activity for the athlete in the window. Past the newest
+// sync only the scheduled syncs can help, so the window only has to be covered up to there
+func (c *ActivityCache) Covers(athleteID int, window ActivityWindow) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return false, err
+	}
+	if len(cached.Synced) == 0 {
+		return false, nil
+	}
+	end := window.End
+	if newest := cached.Synced[len(cached.Synced)-1].End; newest.Before(end) {
+		end = newest
+	}
+	if end.Before(window.Start) {
+		return false, nil
+	}
+	for _, r := range cached.Synced {
+		if !r.Start.After(window.Start) && !r.End.Before(end) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SyncedTo is the end of the newest synced range, zero if we've never synced the athlete. Only syncs
+// move it, activities put one at a time (webhooks) don't
+func (c *ActivityCache) SyncedTo(athleteID int) (time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(cached.Synced) == 0 {
+		return time.Time{}, nil
+	}
+	return cached.Synced[len(cached.Synced)-1].End, nil
+}
+
+// Put adds or replaces activities. synced is the range that was fully synced, pass a zero window for
+// one-off updates. Nothing after now can have been synced, so synced is cut off there
+func (c *ActivityCache) Put(athleteID int, activities []SummaryActivity, synced ActivityWindow) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return err
+	}
+	for _, activity := range activities {
+		cached.Activities[activity.ID] = activity
+	}
+	if !synced.Start.IsZero() {
+		now := time.Now()
+		end := synced.End
+		if end.After(now) {
+			end = now
+		}
+		if end.After(synced.Start) {
+			cached.addSynced(syncedRange{Start: synced.Start, End: end})
+		}
+		cached.LastSync = now
+		cached.ThrottledAt = time.Time{}
+	}
+	return c.save(cached)
+}
+
+// MarkThrottled records that we couldn't sync the athlete because strava is rate limiting us
+func (c *ActivityCache) MarkThrottled(athleteID int, at time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return err
+	}
+	cached.ThrottledAt = at
+	return c.save(cached)
+}
+
+// SyncStatus is when the athlete was last synced, and when we were last throttled trying to (zero if we weren't)
+func (c *ActivityCache) SyncStatus(athleteID int) (lastSync time.Time, throttledAt time.Time, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return cached.LastSync, cached.ThrottledAt, nil
+}
+
+func (c *ActivityCache) Delete(athleteID int, activityID int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return err
+	}
+	if _, ok := cached.Activities[activityID]; !ok {
+		return nil
+	}
+	delete(cached.Activities, activityID)
+	return c.save(cached)
+}
+
+// RemoveAthlete throws away everything we have for the athlete
+func (c *ActivityCache) RemoveAthlete(athleteID int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.athletes, athleteID)
+	err := os.Remove(c.athletePath(athleteID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Activities returns the athlete's cached activities that started inside the window, oldest first
+func (c *ActivityCache) Activities(athleteID int, window ActivityWindow) ([]SummaryActivity, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, err := c.load(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	activities := []SummaryActivity{}
+	for _, activity := range cached.Activities {
+		if window.Contains(activity.StartDate) {
+			activities = append(activities, activity)
+		}
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].StartDate.Before(activities[j].StartDate) })
+	return activities, nil
+}
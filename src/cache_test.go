@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityCacheCovers(t *testing.T) {
+	year := func(y int) time.Time { return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC) }
+	yearWindow := func(y int) ActivityWindow { return ActivityWindow{Start: year(y), End: year(y + 1)} }
+	thisYear := time.Now().Year()
+	future := time.Now().AddDate(1, 0, 0)
+	tests := []struct {
+		name     string
+		syncs    []ActivityWindow
+		covers   ActivityWindow
+		expected bool
+	}{
+		{"sync up to now", []ActivityWindow{{Start: year(thisYear), End: future}}, yearWindow(thisYear), true},
+		{"a past year on its own", []ActivityWindow{{Start: year(2022), End: year(2023)}}, yearWindow(2022), true},
+		{"a past year on its own isn't the present", []ActivityWindow{{Start: year(2022), End: year(2023)}}, yearWindow(thisYear), false},
+		{"a past year that doesn't reach the present stays synced", []ActivityWindow{{Start: year(thisYear), End: future}, {Start: year(2022), End: year(2023)}}, yearWindow(2022), true},
+		{"a past year that doesn't reach the present leaves it alone", []ActivityWindow{{Start: year(thisYear), End: future}, {Start: year(2022), End: year(2023)}}, yearWindow(thisYear), true},
+		{"the gap between them isn't synced", []ActivityWindow{{Start: year(thisYear), End: future}, {Start: year(2022), End: year(2023)}}, yearWindow(2023), false},
+		{"ranges that touch join up", []ActivityWindow{{Start: year(2022), End: year(2023)}, {Start: year(2023), End: year(2024)}}, ActivityWindow{Start: year(2022), End: year(2024)}, true},
+		{"a later sync doesn't undo an earlier one", []ActivityWindow{{Start: year(2023), End: future}, {Start: year(thisYear), End: future}}, yearWindow(2023), true},
+		{"part of a year isn't all of it", []ActivityWindow{{Start: year(2022).AddDate(0, 6, 0), End: year(2023)}}, yearWindow(2022), false},
+		{"one-off updates don't count", []ActivityWindow{{}}, yearWindow(thisYear), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache, err := NewActivityCache(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, synced := range test.syncs {
+				err = cache.Put(1, []SummaryActivity{}, synced)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			covered, err := cache.Covers(1, test.covers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if covered != test.expected {
+				t.Errorf("Covers(%v) = %v, want %v", test.covers, covered, test.expected)
+			}
+		})
+	}
+}
+
+func TestActivityCacheSyncedToIgnoresOneOffUpdates(t *testing.T) {
+	cache, err := NewActivityCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().AddDate(0, -1, 0)
+	err = cache.Put(1, []SummaryActivity{}, ActivityWindow{Start: start, End: start.AddDate(0, 0, 7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A webhook for an activity newer than the last sync
+	err = cache.Put(1, []SummaryActivity{{ID: 1, StartDate: time.Now()}}, ActivityWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncedTo, err := cache.SyncedTo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !syncedTo.Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("SyncedTo = %v, want %v", syncedTo, start.AddDate(0, 0, 7))
+	}
+}
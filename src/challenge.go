@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const challengesFileName = "challenges.json"
+const defaultChallengeTimezone = "America/New_York"
+const challengeDateLayout = "2006-01-02"
+
+// Challenge is a single run of the miles challenge. Start and End are inclusive dates
+// (YYYY-MM-DD) interpreted in Timezone
+type Challenge struct {
+	Year     int    `json:"year"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+
+	location  *time.Location
+	startTime time.Time
+	endTime   time.Time
+}
+
+var challenges []Challenge
+
+// The timezone of calendar year challenges for years that aren't configured
+var fallbackChallengeTimezone = defaultChallengeTimezone
+
+// parse validates the dates/timezone and fills in the unexported time fields
+func (c *Challenge) parse() error {
+	if c.Timezone == "" {
+		c.Timezone = defaultChallengeTimezone
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return errors.New("Invalid challenge timezone " + c.Timezone + ": " + err.Error())
+	}
+	c.location = loc
+	c.startTime, err = time.ParseInLocation(challengeDateLayout, c.Start, loc)
+	if err != nil {
+		return errors.New("Invalid challenge start date " + c.Start + ": " + err.Error())
+	}
+	endDay, err := time.ParseInLocation(challengeDateLayout, c.End, loc)
+	if err != nil {
+		return errors.New("Invalid challenge end date " + c.End + ": " + err.Error())
+	}
+	// End is inclusive, so the window runs until midnight the day after
+	c.endTime = endDay.AddDate(0, 0, 1)
+	if !c.endTime.After(c.startTime) {
+		return errors.New("Challenge end " + c.End + " is before start " + c.Start)
+	}
+	if c.Year == 0 {
+		c.Year = c.startTime.Year()
+	}
+	return nil
+}
+
+func (c Challenge) Location() *time.Location {
+	return c.location
+}
+
+// Window is the full span of the challenge
+func (c Challenge) Window() ActivityWindow {
+	return ActivityWindow{Start: c.startTime, End: c.endTime}
+}
+
+// Now is the current time in the challenge's timezone. For challenges that are over
+// we pin it to the last moment of the challenge so "today" means the final day
+func (c Challenge) Now() time.Time {
+	now := time.Now().In(c.location)
+	if !now.Before(c.endTime) {
+		return c.endTime.Add(-time.Nanosecond)
+	}
+	return now
+}
+
+// calendarYearChallenge is what we fall back to when nothing is configured
+func calendarYearChallenge(year int, timezone string) (Challenge, error) {
+	c := Challenge{
+		Year:     year,
+		Start:    strconv.Itoa(year) + "-01-01",
+		End:      strconv.Itoa(year) + "-12-31",
+		Timezone: timezone,
+	}
+	err := c.parse()
+	return c, err
+}
+
+func readChallengesFile(path string) ([]Challenge, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileChallenges := []Challenge{}
+	err = json.Unmarshal(data, &fileChallenges)
+	if err != nil {
+		return nil, err
+	}
+	for i := range fileChallenges {
+		err = fileChallenges[i].parse()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileChallenges, nil
+}
+
+// LoadChallenges reads historical challenges from the challenges file, then lets the
+// CHALLENGE_START/CHALLENGE_END/CHALLENGE_TIMEZONE env variables define (or override) the current one
+func LoadChallenges() error {
+	challenges = []Challenge{}
+	path := config.ChallengesFilePath
+	if path == "" {
+		path = config.NonVolatileStorageDir + "/" + challengesFileName
+	}
+	if _, err := os.Stat(path); err == nil {
+		fileChallenges, err := readChallengesFile(path)
+		if err != nil {
+			fmt.Println("Failed to read challenges file " + path + ". Error: " + err.Error())
+			return err
+		}
+		challenges = append(challenges, fileChallenges...)
+	}
+
+	timezone := os.Getenv("CHALLENGE_TIMEZONE")
+	start := os.Getenv("CHALLENGE_START")
+	end := os.Getenv("CHALLENGE_END")
+	if start != "" || end != "" {
+		if start == "" || end == "" {
+			return errors.New("Error: `CHALLENGE_START` and `CHALLENGE_END` must be set together")
+		}
+		envChallenge := Challenge{Start: start, End: end, Timezone: timezone}
+		err := envChallenge.parse()
+		if err != nil {
+			return err
+		}
+		addChallenge(envChallenge)
+	}
+
+	// Between configured challenges it's the calendar year in this timezone, worked out when it's needed
+	if timezone == "" {
+		timezone = defaultChallengeTimezone
+	}
+	_, err := time.LoadLocation(timezone)
+	if err != nil {
+		return errors.New("Invalid challenge timezone " + timezone + ": " + err.Error())
+	}
+	fallbackChallengeTimezone = timezone
+
+	for _, c := range challenges {
+		fmt.Println("Challenge " + strconv.Itoa(c.Year) + ": " + c.Start + " to " + c.End + " (" + c.Timezone + ")")
+	}
+	fmt.Println("Any other year is a calendar year challenge (" + timezone + ")")
+	return nil
+}
+
+// addChallenge adds the challenge, replacing an existing one for the same year
+func addChallenge(c Challenge) {
+	for i := range challenges {
+		if challenges[i].Year == c.Year {
+			challenges[i] = c
+			return
+		}
+	}
+	challenges = append(challenges, c)
+	sort.SliceStable(challenges, func(i, j int) bool { return challenges[i].startTime.Before(challenges[j].startTime) })
+}
+
+// CurrentChallenge is the configured challenge that is running right now. Otherwise it's this year's
+// configured challenge, or failing that the calendar year, so a long running server rolls over on Jan 1
+func CurrentChallenge() Challenge {
+	loc, _ := time.LoadLocation(fallbackChallengeTimezone)
+	now := time.Now()
+	for _, c := range challenges {
+		if c.Window().Contains(now) {
+			return c
+		}
+	}
+	year := now.In(loc).Year()
+	for _, c := range challenges {
+		if c.Year == year {
+			return c
+		}
+	}
+	current, err := calendarYearChallenge(year, fallbackChallengeTimezone)
+	if err != nil {
+		// The timezone was checked by LoadChallenges
+		panic(err)
+	}
+	return current
+}
+
+// challengeConfigured tells us if the year has a challenge in the challenges file
+func challengeConfigured(year int) bool {
+	for _, c := range challenges {
+		if c.Year == year {
+			return true
+		}
+	}
+	return false
+}
+
+// ChallengeForYear looks up a configured challenge. Years that were never configured
+// are treated as a plain calendar year
+func ChallengeForYear(year int) (Challenge, error) {
+	for _, c := range challenges {
+		if c.Year == year {
+			return c, nil
+		}
+	}
+	return calendarYearChallenge(year, fallbackChallengeTimezone)
+}
+
+// wallClockIn takes a time whose wall clock is right but whose zone is meaningless (strava's
+// start_date_local, dates parsed out of the sheet) and pins that wall clock to loc
+func wallClockIn(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+const commandUsage = "Usage: miles-challenge [command]\n" +
+	"    (no command)                   run the server\n" +
+	"    upload-lifts <file.csv|.json>  add lift sessions to the lift log, `-` reads stdin\n" +
+	"    create-webhook <callback url>  subscribe to strava's webhook events, the server has to be running\n"
+
+// RunCommand runs a command line subcommand instead of the server. They only need NON_VOLATILE_STORAGE_DIR,
+// and create-webhook the strava client id, secret and STRAVA_WEBHOOK_VERIFY_TOKEN
+func RunCommand(args []string) error {
+	config.NonVolatileStorageDir = os.Getenv("NON_VOLATILE_STORAGE_DIR")
+	switch args[0] {
+	case "upload-lifts":
+		if len(args) != 2 {
+			return errors.New(commandUsage)
+		}
+		return uploadLiftsCommand(args[1])
+	case "create-webhook":
+		if len(args) != 2 {
+			return errors.New(commandUsage)
+		}
+		return createWebhookCommand(args[1])
+	}
+	return errors.New("Unknown command `" + args[0] + "`\n" + commandUsage)
+}
+
+func uploadLiftsCommand(path string) error {
+	if config.NonVolatileStorageDir == "" {
+		return errors.New("Error: `NON_VOLATILE_STORAGE_DIR` env variable not set")
+	}
+	liftLog = NewLiftLog(config.NonVolatileStorageDir + "/" + liftLogFileName)
+
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	result, err := UploadLifts(data)
+	if err != nil {
+		return err
+	}
+	fmt.Println(result.String())
+	return nil
+}
+
+func createWebhookCommand(callbackUrl string) error {
+	if config.NonVolatileStorageDir == "" {
+		return errors.New("Error: `NON_VOLATILE_STORAGE_DIR` env variable not set")
+	}
+	config.StravaAPIClientID = os.Getenv("STRAVA_API_CLIENT_ID")
+	config.StravaAPIClientSecret = os.Getenv("STRAVA_API_CLIENT_SECRET")
+	config.StravaWebhookVerifyToken = os.Getenv("STRAVA_WEBHOOK_VERIFY_TOKEN")
+	ctx, cancel := context.WithTimeout(context.Background(), webhookEventTimeout)
+	defer cancel()
+	id, err := CreateWebhookSubscription(ctx, callbackUrl)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created strava webhook subscription " + strconv.Itoa(id))
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// The dashboard is built into the binary and only uses our own json api, so it works without internet access
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// RegisterDashboardRoutes serves the dashboard at / and its assets under /static
+func RegisterDashboardRoutes(rtr *mux.Router) {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		// Only possible if the embed directive above is broken
+		panic(err)
+	}
+	fileServer := http.FileServer(http.FS(files))
+	// The file server answers / with index.html
+	rtr.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
+		fileServer.ServeHTTP(w, r)
+	}).Methods("GET")
+	rtr.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fileServer)).Methods("GET")
+}
@@ -0,0 +1,172 @@
+// The dashboard only talks to our own json api, charts are plain svg so nothing is loaded from elsewhere
+(function () {
+  "use strict";
+
+  var SVG = "http://www.w3.org/2000/svg";
+  var COLORS = ["#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"];
+  var CATEGORIES = [
+    { key: "run_miles", name: "Run", color: "#1f77b4" },
+    { key: "hike_miles", name: "Hiked", color: "#2ca02c" },
+    { key: "lift_miles", name: "Lifted", color: "#ff7f0e" }
+  ];
+
+  function getJSON(url) {
+    return fetch(url).then(function (resp) {
+      if (!resp.ok) {
+        throw new Error(url + " returned " + resp.status);
+      }
+      return resp.json();
+    });
+  }
+
+  function el(name, attrs, text) {
+    var node = document.createElementNS(SVG, name);
+    Object.keys(attrs || {}).forEach(function (key) {
+      node.setAttribute(key, attrs[key]);
+    });
+    if (text !== undefined) {
+      node.textContent = text;
+    }
+    return node;
+  }
+
+  function miles(value) {
+    return (value || 0).toFixed(2);
+  }
+
+  function total(counts) {
+    return counts.run_miles + counts.hike_miles + counts.lift_miles;
+  }
+
+  function renderLeaderboard(reports) {
+    var body = document.querySelector("#leaderboard tbody");
+    body.textContent = "";
+    reports.forEach(function (report) {
+      var row = document.createElement("tr");
+      var name = document.createElement("td");
+      if (report.athlete_profile_medium) {
+        var img = document.createElement("img");
+        img.src = report.athlete_profile_medium;
+        img.alt = "";
+        name.appendChild(img);
+      }
+      name.appendChild(document.createTextNode(report.athlete_firstname));
+      var goal = report.goal ? Math.floor(report.goal.percent_complete) + "% of " + miles(report.goal.goal_miles) : "";
+      [String(report.place), null, miles(report.counts.run_miles), miles(report.counts.hike_miles),
+        miles(report.counts.lift_miles), miles(total(report.counts)), goal].forEach(function (text) {
+        if (text === null) {
+          row.appendChild(name);
+          return;
+        }
+        var cell = document.createElement("td");
+        cell.textContent = text;
+        row.appendChild(cell);
+      });
+      body.appendChild(row);
+    });
+  }
+
+  function legend(container, items) {
+    var div = document.createElement("div");
+    div.className = "legend";
+    items.forEach(function (item) {
+      var span = document.createElement("span");
+      var swatch = document.createElement("i");
+      swatch.className = "swatch";
+      swatch.style.background = item.color;
+      span.appendChild(swatch);
+      span.appendChild(document.createTextNode(item.name));
+      div.appendChild(span);
+    });
+    container.appendChild(div);
+  }
+
+  var DAY = 24 * 60 * 60 * 1000;
+
+  function dayNumber(date) {
+    var parts = date.split("-");
+    return Date.UTC(+parts[0], +parts[1] - 1, +parts[2]) / DAY;
+  }
+
+  // renderCumulative draws a running total line per athlete from the period start to today
+  function renderCumulative(daily) {
+    var container = document.getElementById("cumulative");
+    container.textContent = "";
+    var first = dayNumber(daily.period.start.slice(0, 10));
+    var last = Math.min(dayNumber(daily.period.end.slice(0, 10)) - 1, dayNumber(new Date().toISOString().slice(0, 10)));
+    var width = 900, height = 360, left = 50, right = 16, top = 16, bottom = 30;
+    var lines = daily.athletes.map(function (athlete, i) {
+      var sum = 0, points = [[first, 0]];
+      athlete.days.forEach(function (day) {
+        sum += total(day.counts);
+        points.push([dayNumber(day.date), sum]);
+      });
+      points.push([last, sum]);
+      return { name: athlete.athlete_firstname, color: COLORS[i % COLORS.length], points: points, total: sum };
+    });
+    var maxMiles = Math.max.apply(null, [1].concat(lines.map(function (line) { return line.total; })));
+    var x = function (day) { return left + (day - first) / Math.max(last - first, 1) * (width - left - right); };
+    var y = function (value) { return top + (1 - value / maxMiles) * (height - top - bottom); };
+
+    var svg = el("svg", { viewBox: "0 0 " + width + " " + height });
+    for (var i = 0; i <= 4; i++) {
+      var value = maxMiles * i / 4;
+      svg.appendChild(el("line", { "class": "grid", x1: left, x2: width - right, y1: y(value), y2: y(value) }));
+      svg.appendChild(el("text", { x: left - 6, y: y(value) + 4, "text-anchor": "end" }, Math.round(value)));
+    }
+    for (var day = first; day <= last; day++) {
+      var date = new Date(day * DAY);
+      if (date.getUTCDate() === 1) {
+        svg.appendChild(el("text", { x: x(day), y: height - 10, "text-anchor": "middle" },
+          date.toLocaleString("en-US", { month: "short", timeZone: "UTC" })));
+      }
+    }
+    svg.appendChild(el("line", { "class": "axis", x1: left, x2: width - right, y1: y(0), y2: y(0) }));
+    lines.forEach(function (line) {
+      var path = line.points.map(function (point, j) {
+        // Steps, miles only change on the days there were activities
+        var prev = j > 0 ? line.points[j - 1][1] : point[1];
+        return (j === 0 ? "M" : "L" + x(point[0]) + " " + y(prev) + " L") + x(point[0]) + " " + y(point[1]);
+      }).join(" ");
+      svg.appendChild(el("path", { d: path, fill: "none", stroke: line.color, "stroke-width": 2 }));
+    });
+    container.appendChild(svg);
+    legend(container, lines);
+  }
+
+  // renderBreakdown draws a stacked bar of run, hike and lift miles per athlete
+  function renderBreakdown(reports) {
+    var container = document.getElementById("breakdown");
+    container.textContent = "";
+    var width = 900, barHeight = 24, gap = 10, left = 100, right = 70;
+    var height = reports.length * (barHeight + gap) + gap;
+    var maxMiles = Math.max.apply(null, [1].concat(reports.map(function (report) { return total(report.counts); })));
+    var svg = el("svg", { viewBox: "0 0 " + width + " " + Math.max(height, 1) });
+    reports.forEach(function (report, i) {
+      var top = gap + i * (barHeight + gap);
+      var x = left;
+      svg.appendChild(el("text", { x: left - 8, y: top + barHeight / 2 + 4, "text-anchor": "end" }, report.athlete_firstname));
+      CATEGORIES.forEach(function (category) {
+        var w = report.counts[category.key] / maxMiles * (width - left - right);
+        var rect = el("rect", { x: x, y: top, width: w, height: barHeight, fill: category.color });
+        rect.appendChild(el("title", {}, category.name + ": " + miles(report.counts[category.key])));
+        svg.appendChild(rect);
+        x += w;
+      });
+      svg.appendChild(el("text", { x: x + 6, y: top + barHeight / 2 + 4 }, miles(total(report.counts))));
+    });
+    container.appendChild(svg);
+    legend(container, CATEGORIES);
+  }
+
+  var status = document.getElementById("status");
+  Promise.all([getJSON("/api/v1/leaderboard?limit=500"), getJSON("/api/v1/daily-miles")]).then(function (results) {
+    var reports = results[0].items;
+    renderLeaderboard(reports);
+    renderCumulative(results[1]);
+    renderBreakdown(reports);
+    status.textContent = "Updated " + new Date().toLocaleString();
+  }).catch(function (err) {
+    status.textContent = "Couldn't load the challenge: " + err.message;
+  });
+})();
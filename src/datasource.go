@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Categories an activity can count towards in the challenge
+const (
+	CategoryRun  = "run"
+	CategoryHike = "hike"
+	CategoryLift = "lift"
+)
+
+// Activity is a single source-agnostic entry that counts towards the challenge
+type Activity struct {
+	Source   string    `json:"source"`
+	Date     time.Time `json:"date"`
+	Category string    `json:"category"`
+	Miles    float32   `json:"miles"`
+	Minutes  int       `json:"minutes"`
+}
+
+// AthleteActivities are all the activities a data source found for a single athlete.
+// AthleteID is the stable identity (the strava athlete id) used to merge sources together.
+type AthleteActivities struct {
+	AthleteID        int
+	AthleteFirstName string
+	// Avatar url, only some sources know it
+	AthleteProfileMedium string
+	// Location is the athlete's home timezone, where "today" is worked out. nil when the source doesn't know it
+	Location   *time.Location
+	Activities []Activity
+	// Stale is set when the source couldn't get up to date data (e.g. strava rate limiting)
+	Stale       bool
+	StaleReason string
+	// Error is set when the source couldn't get anything at all for the athlete
+	Error string
+}
+
+// ActivityWindow is the range of time [Start, End) we want activities for
+type ActivityWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (w ActivityWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// AthleteDataSource is anything that can tell us what athletes did over a window of time.
+// Strava and the google sheet are the two we have today.
+type AthleteDataSource interface {
+	Name() string
+	FetchActivities(ctx context.Context, window ActivityWindow) ([]AthleteActivities, error)
+}
+
+var dataSources []AthleteDataSource
+
+// RegisterDataSource adds a source that GenerateReport will pull activities from
+func RegisterDataSource(source AthleteDataSource) {
+	dataSources = append(dataSources, source)
+}
+
+// add puts the activity into the correct bucket
+func (a *AthleteCounts) add(activity Activity) {
+	switch activity.Category {
+	case CategoryRun:
+		a.RunMiles += activity.Miles
+		a.RunMinutes += activity.Minutes
+	case CategoryHike:
+		a.HikeMiles += activity.Miles
+		a.HikeMinutes += activity.Minutes
+	case CategoryLift:
+		a.LiftMiles += activity.Miles
+		a.LiftMinutes += activity.Minutes
+	}
+}
+
+// AddActivity counts the activity towards the year, and towards the day if it happened on the same day as now.
+// now should be in the athlete's home timezone, activity dates are in the timezone they happened in
+func (r *UserReport) AddActivity(activity Activity, now time.Time) {
+	r.YearToDate.add(activity)
+	if sameDay(activity.Date, now) {
+		r.Day.add(activity)
+	}
+}
+
+// athleteIDForName is for sources (like the google sheet) that only know athletes by name.
+// We match the name against the first name of our registered strava users.
+func athleteIDForName(name string, users []StravaUser) (int, bool) {
+	for _, user := range users {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(user.Athlete.Firstname)) {
+			return user.Athlete.ID, true
+		}
+	}
+	return 0, false
+}
+
+// mergeAthleteActivities combines the results of every source into one report per athlete. "today" is
+// now's day in the athlete's home timezone, the first one a source knows, falling back to now's location
+func mergeAthleteActivities(sourceResults [][]AthleteActivities, now time.Time) []UserReport {
+	reports := []UserReport{}
+	indexByID := map[int]int{}
+	locations := map[int]*time.Location{}
+	for _, athletes := range sourceResults {
+		for _, athlete := range athletes {
+			if _, known := locations[athlete.AthleteID]; !known && athlete.Location != nil {
+				locations[athlete.AthleteID] = athlete.Location
+			}
+		}
+	}
+	for _, athletes := range sourceResults {
+		for _, athlete := range athletes {
+			i, exists := indexByID[athlete.AthleteID]
+			if !exists {
+				reports = append(reports, UserReport{AthleteID: athlete.AthleteID, AthleteFirstName: athlete.AthleteFirstName})
+				i = len(reports) - 1
+				indexByID[athlete.AthleteID] = i
+			}
+			if reports[i].AthleteFirstName == "" {
+				reports[i].AthleteFirstName = athlete.AthleteFirstName
+			}
+			if reports[i].AthleteProfileMedium == "" {
+				reports[i].AthleteProfileMedium = athlete.AthleteProfileMedium
+			}
+			if athlete.Stale {
+				reports[i].Stale = true
+				reports[i].StaleReason = athlete.StaleReason
+			}
+			if athlete.Error != "" {
+				reports[i].Error = athlete.Error
+			}
+			athleteNow := now
+			if loc, known := locations[athlete.AthleteID]; known {
+				athleteNow = now.In(loc)
+			}
+			for _, activity := range athlete.Activities {
+				reports[i].AddActivity(activity, athleteNow)
+			}
+		}
+	}
+	return reports
+}
+
+// FetchFromAllSources asks every registered source for activities. A source that fails
+// is logged and skipped so one broken source doesn't take down the whole report
+func FetchFromAllSources(ctx context.Context, window ActivityWindow) [][]AthleteActivities {
+	results := [][]AthleteActivities{}
+	for _, source := range dataSources {
+		athletes, err := source.FetchActivities(ctx, window)
+		if err != nil {
+			fmt.Println("Failed to fetch activities from source " + source.Name() + ". Error: " + err.Error())
+			continue
+		}
+		results = append(results, athletes)
+	}
+	return results
+}
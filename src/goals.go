@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// GoalProgress is how an athlete is doing against their challenge miles goal. Today counts as a day
+// that's already been run, the daily report goes out in the evening
+type GoalProgress struct {
+	GoalMiles       float32 `json:"goal_miles"`
+	PercentComplete float32 `json:"percent_complete"`
+	// PaceMiles is how far ahead (positive) or behind (negative) of an even pace to the goal they are
+	PaceMiles float32 `json:"pace_miles"`
+	// RequiredDailyMiles is what they need every remaining day to make the goal, 0 once they have
+	RequiredDailyMiles float32 `json:"required_daily_miles"`
+	// ProjectedMiles is where they'll finish if they keep up their pace so far
+	ProjectedMiles float32 `json:"projected_miles"`
+}
+
+// calendarDay is t's day as a count of days, so differences between days ignore DST
+func calendarDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// challengeDays is how many days of the challenge have started by now, today included, and how many it has in total
+func challengeDays(challenge Challenge, now time.Time) (int, int) {
+	window := challenge.Window()
+	total := calendarDay(window.End.Add(-time.Nanosecond)) - calendarDay(window.Start) + 1
+	elapsed := calendarDay(now.In(challenge.Location())) - calendarDay(window.Start) + 1
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed > total {
+		elapsed = total
+	}
+	return elapsed, total
+}
+
+func computeGoalProgress(goal, miles float32, elapsedDays, totalDays int) GoalProgress {
+	progress := GoalProgress{GoalMiles: goal}
+	if goal > 0 {
+		progress.PercentComplete = miles / goal * 100
+	}
+	if totalDays > 0 {
+		progress.PaceMiles = miles - goal*float32(elapsedDays)/float32(totalDays)
+	}
+	if left, remainingDays := goal-miles, totalDays-elapsedDays; left > 0 && remainingDays > 0 {
+		progress.RequiredDailyMiles = left / float32(remainingDays)
+	}
+	if elapsedDays > 0 {
+		progress.ProjectedMiles = miles / float32(elapsedDays) * float32(totalDays)
+	}
+	return progress
+}
+
+// addGoalProgress fills in the goal of every athlete that has one for the challenge
+func addGoalProgress(reports []UserReport, challenge Challenge) {
+	users, err := ReadUserCredentials()
+	if err != nil {
+		fmt.Println("Failed to read goals. Error: " + err.Error())
+		return
+	}
+	goals := map[int]float32{}
+	for _, user := range users {
+		if goal, ok := user.Goals[challenge.Year]; ok {
+			goals[user.Athlete.ID] = goal
+		}
+	}
+	elapsed, total := challengeDays(challenge, challenge.Now())
+	for i := range reports {
+		goal, ok := goals[reports[i].AthleteID]
+		if !ok {
+			continue
+		}
+		progress := computeGoalProgress(goal, reports[i].YearToDate.Total(), elapsed, total)
+		reports[i].Goal = &progress
+	}
+}
+
+// formatGoal is one line about the goal, like "Goal: 500 (42%), 12.3 ahead of pace, 1.2 a day to go, on pace for 540"
+func formatGoal(goal GoalProgress) string {
+	text := "Goal: " + floatStr(goal.GoalMiles) + " (" + strconv.Itoa(int(math.Floor(float64(goal.PercentComplete)))) + "%)"
+	if goal.PaceMiles >= 0 {
+		text += ", " + floatStr(goal.PaceMiles) + " ahead of pace"
+	} else {
+		text += ", " + floatStr(-goal.PaceMiles) + " behind pace"
+	}
+	if goal.RequiredDailyMiles > 0 {
+		text += ", " + floatStr(goal.RequiredDailyMiles) + " a day to go"
+	}
+	return text + ", on pace for " + floatStr(goal.ProjectedMiles)
+}
+
+// SetUserGoal sets the athlete's challenge miles goal for the challenge year, 0 removes it
+func SetUserGoal(athleteID int, year int, miles float32) error {
+	if miles < 0 || math.IsNaN(float64(miles)) || math.IsInf(float64(miles), 0) {
+		return errors.New("Goals have to be 0 miles or more")
+	}
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		if users[i].Athlete.ID != athleteID {
+			continue
+		}
+		if users[i].Goals == nil {
+			users[i].Goals = map[int]float32{}
+		}
+		if miles == 0 {
+			delete(users[i].Goals, year)
+		} else {
+			users[i].Goals[year] = miles
+		}
+		return writeUserCredentials(users)
+	}
+	return errors.New("No registered athlete with id " + strconv.Itoa(athleteID))
+}
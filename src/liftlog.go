@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bclouser/miles-challenge/sheets"
+)
+
+const liftLogFileName = "lift_log.json"
+
+// Plenty for a whole year of sessions
+const maxLiftUploadBytes = 5 << 20
+
+// LiftLog holds lift sessions uploaded to us directly, for athletes that can't use the google sheet.
+// Stored in the non-volatile storage dir in the same shape the sheet gives us, keyed by first name
+type LiftLog struct {
+	mutex sync.Mutex
+	path  string
+}
+
+var liftLog *LiftLog
+
+func NewLiftLog(path string) *LiftLog {
+	return &LiftLog{path: path}
+}
+
+// Sessions is every logged session. Read from disk each time so uploads from the cli show up straight away
+func (l *LiftLog) Sessions() (map[string][]sheets.LiftSession, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.read()
+}
+
+func (l *LiftLog) read() (map[string][]sheets.LiftSession, error) {
+	sessions := map[string][]sheets.LiftSession{}
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &sessions)
+	return sessions, err
+}
+
+// sameLiftSession is how we spot a session that's been uploaded before
+func sameLiftSession(a, b sheets.LiftSession) bool {
+	return a.Date.Equal(b.Date) && a.MinuteDuration == b.MinuteDuration && a.MileConversion == b.MileConversion
+}
+
+// Add stores the sessions, skipping any the athlete already had before this upload. Sessions in the same
+// upload are never duplicates of each other, two identical sessions in a day are both counted. Names match case-insensitively
+func (l *LiftLog) Add(sessionsByName map[string][]sheets.LiftSession) (added int, duplicates int, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	existing, err := l.read()
+	if err != nil {
+		return 0, 0, err
+	}
+	for name, sessions := range sessionsByName {
+		for existingName := range existing {
+			if strings.EqualFold(existingName, name) {
+				name = existingName
+				break
+			}
+		}
+		stored := existing[name]
+		for _, session := range sessions {
+			duplicate := false
+			for _, logged := range stored {
+				if sameLiftSession(logged, session) {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				duplicates++
+				continue
+			}
+			existing[name] = append(existing[name], session)
+			added++
+		}
+		sort.Slice(existing[name], func(i, j int) bool { return existing[name][i].Date.Before(existing[name][j].Date) })
+	}
+	if added == 0 {
+		return added, duplicates, nil
+	}
+	data, err := json.Marshal(existing)
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, duplicates, writeFileAtomic(l.path, data, 0644)
+}
+
+// LiftUploadResult is what an upload did, sent back as json
+type LiftUploadResult struct {
+	Added      int      `json:"added"`
+	Duplicates int      `json:"duplicates"`
+	Problems   []string `json:"problems"`
+}
+
+// ParseLiftUpload reads lift sessions from csv (with an athlete, date, minutes and miles header row)
+// or a json list of {"athlete", "date", "minutes", "miles"}. Rows that can't be read come back as problems
+func ParseLiftUpload(data []byte) (map[string][]sheets.LiftSession, []sheets.RowProblem, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil, errors.New("Nothing uploaded")
+	}
+	rows := [][]interface{}{}
+	if trimmed[0] == '[' {
+		entries := []map[string]interface{}{}
+		err := json.Unmarshal(trimmed, &entries)
+		if err != nil {
+			return nil, nil, errors.New("Invalid json: " + err.Error())
+		}
+		rows = append(rows, []interface{}{"athlete", "date", "minutes", "miles"})
+		for _, entry := range entries {
+			miles, ok := entry["miles"]
+			if !ok {
+				miles = entry["mile_conversion"]
+			}
+			rows = append(rows, []interface{}{jsonCell(entry["athlete"]), jsonCell(entry["date"]), jsonCell(entry["minutes"]), jsonCell(miles)})
+		}
+	} else {
+		reader := csv.NewReader(bytes.NewReader(trimmed))
+		reader.FieldsPerRecord = -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, errors.New("Invalid csv: " + err.Error())
+			}
+			row := []interface{}{}
+			for _, field := range record {
+				row = append(row, field)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return sheets.ParseTidyRows(rows)
+}
+
+// jsonCell keeps numbers as numbers and everything else as text, like a sheet cell. A json date
+// has to be text, a number would be read as a sheet serial date
+func jsonCell(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64, string:
+		return v
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// UploadLifts parses and stores an upload
+func UploadLifts(data []byte) (LiftUploadResult, error) {
+	result := LiftUploadResult{Problems: []string{}}
+	sessions, problems, err := ParseLiftUpload(data)
+	if err != nil {
+		return result, err
+	}
+	for _, problem := range problems {
+		result.Problems = append(result.Problems, problem.String())
+	}
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return result, err
+	}
+	// Reports would skip them, so don't tell anyone they were added
+	names := []string{}
+	for name := range sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := athleteIDForName(name, users); !ok {
+			result.Problems = append(result.Problems, strconv.Itoa(len(sessions[name]))+" session(s) for "+name+" skipped, nobody registered with strava is called that")
+			delete(sessions, name)
+		}
+	}
+	result.Added, result.Duplicates, err = liftLog.Add(sessions)
+	return result, err
+}
+
+func (r LiftUploadResult) String() string {
+	text := strconv.Itoa(r.Added) + " lift sessions added, " + strconv.Itoa(r.Duplicates) + " already logged"
+	for _, problem := range r.Problems {
+		text += "\n    " + problem
+	}
+	return text
+}
+
+// LiftLogDataSource reports the uploaded lift sessions
+type LiftLogDataSource struct{}
+
+func (s LiftLogDataSource) Name() string {
+	return "lift-log"
+}
+
+func (s LiftLogDataSource) FetchActivities(ctx context.Context, window ActivityWindow) ([]AthleteActivities, error) {
+	sessions, err := liftLog.Sessions()
+	if err != nil || len(sessions) == 0 {
+		return []AthleteActivities{}, err
+	}
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return []AthleteActivities{}, err
+	}
+	return liftSessionActivities(s.Name(), sessions, users, window), nil
+}
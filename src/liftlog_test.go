@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bclouser/miles-challenge/sheets"
+)
+
+func TestLiftLogAddDuplicates(t *testing.T) {
+	day := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	session := sheets.LiftSession{Date: day, MinuteDuration: 30, MileConversion: 1.5}
+	tests := []struct {
+		name           string
+		uploads        []map[string][]sheets.LiftSession
+		wantAdded      int
+		wantDuplicates int
+	}{
+		{"two identical sessions in one upload", []map[string][]sheets.LiftSession{{"Peter": {session, session}}}, 2, 0},
+		{"the same upload twice", []map[string][]sheets.LiftSession{{"Peter": {session}}, {"Peter": {session}}}, 0, 1},
+		{"names match whatever the case", []map[string][]sheets.LiftSession{{"Peter": {session}}, {"peter": {session}}}, 0, 1},
+		{"another athlete's session", []map[string][]sheets.LiftSession{{"Peter": {session}}, {"Ben": {session}}}, 1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := NewLiftLog(filepath.Join(t.TempDir(), liftLogFileName))
+			added, duplicates := 0, 0
+			for _, upload := range test.uploads {
+				var err error
+				added, duplicates, err = log.Add(upload)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if added != test.wantAdded || duplicates != test.wantDuplicates {
+				t.Errorf("last upload added %d with %d duplicates, want %d and %d", added, duplicates, test.wantAdded, test.wantDuplicates)
+			}
+		})
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,10 +14,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/bclouser/miles-challenge/oauthstate"
 	"github.com/bclouser/miles-challenge/sheets"
-	// "github.com/bclouser/miles-challenge/slack"
+	"github.com/bclouser/miles-challenge/slack"
 	"github.com/go-co-op/gocron"
 	"github.com/gorilla/mux"
 )
@@ -24,6 +27,39 @@ import (
 const stravaUsersFileName = "strava_users.json"
 const stravaApiClientFileName = "strava_api_client.json"
 const authCodeInputUrl = "https://miles-challenge.multiplewanda.com/api/gc/auth-code"
+const defaultStravaRedirectUrl = "https://miles-challenge.multiplewanda.com/api/strava/auth-code"
+const stravaAuthorizeEndpoint = "https://www.strava.com/oauth/authorize"
+
+// Pending strava logins, a user has this long to click through strava's authorize page
+const stravaOAuthStateTTL = 10 * time.Minute
+
+var stravaOAuthStates = oauthstate.NewStore(stravaOAuthStateTTL)
+
+// The oauth state is also kept in a cookie so the callback has to come from the browser that started the login,
+// otherwise anyone could get a state from /api/strava/authorize and use it in a forged callback
+const stravaOAuthStateCookie = "strava_oauth_state"
+
+func setStravaOAuthStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stravaOAuthStateCookie,
+		Value:    state,
+		Path:     "/api/strava",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.StravaRedirectUrl, "https://"),
+		// Lax still sends it on strava's redirect back to us, which is a top level GET
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// stravaOAuthStateFromBrowser tells us if the state is the one we gave this browser
+func stravaOAuthStateFromBrowser(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(stravaOAuthStateCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) == 1
+}
 
 var APIClientConfig StravaAPIClient
 
@@ -37,6 +73,7 @@ var APIClientConfig StravaAPIClient
 
 type Config struct {
 	SlackChannelHookUrl            string
+	SlackSigningSecret             string
 	StravaAPIClientID              string
 	StravaAPIClientSecret          string
 	StravaAPITokenEndpoint         string
@@ -44,6 +81,21 @@ type Config struct {
 	GoogleCloudCredentialsFilePath string
 	GoogleCloudSavedTokenPath      string // Where the saved token will be stored
 	NonVolatileStorageDir          string
+	ChallengesFilePath             string
+	ActivityRulesFilePath          string
+	SheetsLayoutFilePath           string
+	AthleteTimezonesFilePath       string
+	SheetsWriteSummary             bool
+	SheetsSummaryTab               string
+	LiftUploadToken                string // Bearer token for /api/lifts, uploads are turned off without it
+	StravaSyncIntervalMinutes      int
+	StravaWebhookVerifyToken       string
+	StravaWebhookSubscriptionID    int // Only needed for subscriptions not made with the create-webhook command
+	StravaRedirectUrl              string
+	StravaOAuthScopes              string
+	StravaFetchConcurrency         int
+	HTTPClientTimeout              time.Duration // For every outbound call to strava, slack and google
+	SlackCommandTimeout            time.Duration
 }
 
 var config Config
@@ -53,8 +105,29 @@ func metersToMiles(meters float32) float32 {
 	return meters / metersPerMile
 }
 
+// challengeFromRequest is the challenge for the request's `year` query parameter, the current one without it
+func challengeFromRequest(r *http.Request) (Challenge, error) {
+	yearStr := r.URL.Query().Get("year")
+	if yearStr == "" {
+		return CurrentChallenge(), nil
+	}
+	year, err := strconv.Atoi(yearStr)
+	if err != nil {
+		return Challenge{}, errors.New("Invalid year " + html.EscapeString(yearStr))
+	}
+	// Anyone can ask, and a made up year would have us paging through strava for every athlete
+	if !challengeConfigured(year) && year != CurrentChallenge().Year {
+		return Challenge{}, errors.New("No challenge for year " + html.EscapeString(yearStr))
+	}
+	challenge, err := ChallengeForYear(year)
+	if err != nil {
+		return Challenge{}, errors.New("No challenge for year " + html.EscapeString(yearStr) + ": " + err.Error())
+	}
+	return challenge, nil
+}
+
 // Refresh Token will get a new token and replace the existing tokens in the stored config file
-func RefreshToken(user StravaUser, persist bool) (StravaUser, error) {
+func RefreshToken(ctx context.Context, user StravaUser, persist bool) (StravaUser, error) {
 	formData := url.Values{
 		"client_id":     {APIClientConfig.ClientID},
 		"client_secret": {APIClientConfig.ClientSecret},
@@ -62,39 +135,41 @@ func RefreshToken(user StravaUser, persist bool) (StravaUser, error) {
 		"grant_type":    {"refresh_token"},
 	}
 	// Send request to strava to authorize user
-	req, err := http.NewRequest(http.MethodPost, APIClientConfig.TokenEndpoint, strings.NewReader(formData.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, APIClientConfig.TokenEndpoint, strings.NewReader(formData.Encode()))
 	if err != nil {
 		fmt.Println("Failed to create request to strava")
 		return user, err
 	}
 	req.Header.Add("Content-Type", "multipart/form-data")
-	respBuf := bytes.Buffer{}
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, body, err := stravaHTTP.Do(req)
 	// Non nil errors means the http request didn't get off the ground. It doesn't mean non 2XX
 	if err != nil {
 		fmt.Println("Failed to send out http request")
 		return user, err
 	}
 
-	respBuf.ReadFrom(resp.Body)
-	resp.Body.Close()
-
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		fmt.Println("Strava turned down the refresh token for " + user.Athlete.Firstname + ": " + resp.Status)
+		return user, ErrStravaUnauthorized
+	}
 	if resp.StatusCode >= 300 {
 		fmt.Println("Request returned http status: " + resp.Status)
 		return user, errors.New("Request returned non 200 status " + resp.Status)
 	}
 	freshTokenUser := StravaUser{}
-	err = json.Unmarshal(respBuf.Bytes(), &freshTokenUser)
+	err = json.Unmarshal(body, &freshTokenUser)
 	if err != nil {
 		fmt.Println("Failed to unmarshal json from strava response into user. Error: " + err.Error())
 		return user, err
 	}
 	user.AccessToken = freshTokenUser.AccessToken
 	user.RefreshToken = freshTokenUser.RefreshToken
+	user.ExpiresAt = freshTokenUser.ExpiresAt
+	user.ExpiresIn = freshTokenUser.ExpiresIn
 
 	if persist {
-		if AddUserCredentials(user) != nil {
+		err = AddUserCredentials(user)
+		if err != nil {
 			fmt.Println("Failed to add updated user to credentials file")
 			return user, err
 		}
@@ -102,44 +177,39 @@ func RefreshToken(user StravaUser, persist bool) (StravaUser, error) {
 	return user, nil
 }
 
-func GetUserActivitiesForCurrentYear(accessToken string) ([]SummaryActivity, error) {
+func GetUserActivitiesForCurrentYear(ctx context.Context, accessToken string, window ActivityWindow) ([]SummaryActivity, error) {
 	// "https://www.strava.com/api/v3/athlete/activities?before=&after=&page=&per_page=" "Authorization: Bearer [[token]]"
 	activities := []SummaryActivity{}
-	beginYear, _ := time.Parse("2006-01-02", "2021-12-31")
 	const pageLen = 100
 
 	// Deal with pagination
 	for i := 0; i < 100; i++ {
 		pageActivities := []SummaryActivity{}
 		params := url.Values{}
-		params.Add("after", strconv.FormatInt(beginYear.Unix(), 10))
+		params.Add("after", strconv.FormatInt(window.Start.Unix(), 10))
+		params.Add("before", strconv.FormatInt(window.End.Unix(), 10))
 		params.Add("per_page", strconv.Itoa(pageLen))
 		params.Add("page", strconv.Itoa(1+i))
 		// fmt.Println("Params look like: " + params.Encode())
-		req, err := http.NewRequest(http.MethodGet, "https://www.strava.com/api/v3/athlete/activities?"+params.Encode(), nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.strava.com/api/v3/athlete/activities?"+params.Encode(), nil)
 		if err != nil {
 			fmt.Println("Failed to create request to Get user activities on strava. Error " + err.Error())
 			return activities, err
 		}
 		req.Header.Add("Authorization", "Bearer "+accessToken)
-		respBuf := bytes.Buffer{}
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, body, err := stravaHTTP.Do(req)
 		// Non nil errors means the http request didn't get off the ground. It doesn't mean non 2XX
 		if err != nil {
 			fmt.Println("Failed to send out http request. Error: " + err.Error())
 			return activities, err
 		}
 
-		respBuf.ReadFrom(resp.Body)
-		resp.Body.Close()
-
 		if resp.StatusCode >= 300 {
 			fmt.Println("Request returned http status: " + resp.Status)
-			fmt.Println(respBuf.String())
-			return activities, err
+			fmt.Println(string(body))
+			return activities, errors.New("Request returned non 200 status " + resp.Status)
 		}
-		err = json.Unmarshal(respBuf.Bytes(), &pageActivities)
+		err = json.Unmarshal(body, &pageActivities)
 		if err != nil {
 			fmt.Println("Failed to unmarshal json strava activities into structs. Error: " + err.Error())
 			return activities, err
@@ -152,6 +222,43 @@ func GetUserActivitiesForCurrentYear(accessToken string) ([]SummaryActivity, err
 	return activities, nil
 }
 
+// ErrStravaUnauthorized is returned when strava won't refresh a user's token, usually because they deauthorized us
+var ErrStravaUnauthorized = errors.New("Strava rejected the refresh token")
+
+// ErrStravaNotFound is returned when strava doesn't know about (or won't show us) an activity
+var ErrStravaNotFound = errors.New("Strava returned 404 not found")
+
+// GetStravaActivity fetches a single activity. Strava returns a DetailedActivity which is a
+// superset of SummaryActivity so we only keep the summary fields
+func GetStravaActivity(ctx context.Context, accessToken string, activityID int64) (SummaryActivity, error) {
+	activity := SummaryActivity{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.strava.com/api/v3/activities/"+strconv.FormatInt(activityID, 10), nil)
+	if err != nil {
+		fmt.Println("Failed to create request to Get activity on strava. Error " + err.Error())
+		return activity, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	resp, body, err := stravaHTTP.Do(req)
+	// Non nil errors means the http request didn't get off the ground. It doesn't mean non 2XX
+	if err != nil {
+		fmt.Println("Failed to send out http request. Error: " + err.Error())
+		return activity, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return activity, ErrStravaNotFound
+	}
+	if resp.StatusCode >= 300 {
+		fmt.Println("Request returned http status: " + resp.Status)
+		return activity, errors.New("Request returned non 200 status " + resp.Status)
+	}
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		fmt.Println("Failed to unmarshal json strava activity into struct. Error: " + err.Error())
+	}
+	return activity, err
+}
+
 // Function legacy... We don't have a strava config file anymore
 func ReadStravaConfig() (StravaAPIClient, error) {
 	apiConfig := StravaAPIClient{}
@@ -176,7 +283,12 @@ func ReadUserCredentials() ([]StravaUser, error) {
 	return usersFile, err
 }
 
+// Token refreshes, registrations and deauthorizations can all rewrite the credentials file at once
+var credentialsMutex sync.Mutex
+
 func AddUserCredentials(user StravaUser) error {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
 	// Should we care about duplicates????
 	users := []StravaUser{}
 	if _, err := os.Stat(config.NonVolatileStorageDir + "/" + stravaUsersFileName); err == nil {
@@ -192,6 +304,8 @@ func AddUserCredentials(user StravaUser) error {
 		for i, existingUser := range users {
 			if user.Athlete.ID == existingUser.Athlete.ID {
 				fmt.Println("User with ID: " + strconv.Itoa(user.Athlete.ID) + " already exists in stored config. Overwriting...")
+				// Goals are only changed by SetUserGoal, strava doesn't know about them
+				user.Goals = existingUser.Goals
 				// I am not actually sure this works... the whole modifying a list inside a for-loop thing
 				users[i] = user
 				overWritten = true
@@ -201,26 +315,141 @@ func AddUserCredentials(user StravaUser) error {
 	if !overWritten {
 		users = append(users, user)
 	}
+	return writeUserCredentials(users)
+}
+
+// writeUserCredentials replaces the credentials file, callers hold credentialsMutex
+func writeUserCredentials(users []StravaUser) error {
 	fileBuf, err := json.Marshal(users)
 	if err != nil {
 		fmt.Println("Failed to marshal file as json: " + err.Error())
 		return err
 	}
-	return ioutil.WriteFile(config.NonVolatileStorageDir+"/"+stravaUsersFileName, fileBuf, 0755)
+	return writeFileAtomic(config.NonVolatileStorageDir+"/"+stravaUsersFileName, fileBuf, 0600)
+}
+
+// FindUserCredentials looks up a registered user by their strava athlete id
+func FindUserCredentials(athleteID int) (StravaUser, bool, error) {
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return StravaUser{}, false, err
+	}
+	for _, user := range users {
+		if user.Athlete.ID == athleteID {
+			return user, true, nil
+		}
+	}
+	return StravaUser{}, false, nil
+}
+
+// RemoveUserCredentials drops the user from the credentials file. Removing a user that isn't there is not an error
+func RemoveUserCredentials(athleteID int) error {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return err
+	}
+	remaining := []StravaUser{}
+	for _, user := range users {
+		if user.Athlete.ID != athleteID {
+			remaining = append(remaining, user)
+		}
+	}
+	if len(remaining) == len(users) {
+		return nil
+	}
+	return writeUserCredentials(remaining)
+}
+
+// scopeGranted checks strava's comma separated granted scopes. activity:read_all implies activity:read
+func scopeGranted(granted, want string) bool {
+	for _, scope := range strings.Split(granted, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope == want || scope == want+"_all" {
+			return true
+		}
+	}
+	return false
 }
 
 func Init() error {
 	config.SlackChannelHookUrl = os.Getenv("SLACK_CHANNEL_HOOK_URL")
+	config.SlackSigningSecret = os.Getenv("SLACK_SIGNING_SECRET")
 	config.StravaAPIClientID = os.Getenv("STRAVA_API_CLIENT_ID")
 	config.StravaAPIClientSecret = os.Getenv("STRAVA_API_CLIENT_SECRET")
 	config.StravaAPITokenEndpoint = os.Getenv("STRAVA_TOKEN_ENDPOINT")
 	config.GoogleSheetsID = os.Getenv("GOOGLE_SHEETS_SHEET_ID")
 	config.GoogleCloudCredentialsFilePath = os.Getenv("GOOGLE_CLOUD_CREDENTIALS_PATH")
 	config.NonVolatileStorageDir = os.Getenv("NON_VOLATILE_STORAGE_DIR")
+	config.ChallengesFilePath = os.Getenv("CHALLENGES_FILE")
+	config.ActivityRulesFilePath = os.Getenv("ACTIVITY_RULES_FILE")
+	config.SheetsLayoutFilePath = os.Getenv("SHEETS_LAYOUT_FILE")
+	config.AthleteTimezonesFilePath = os.Getenv("ATHLETE_TIMEZONES_FILE")
+	config.SheetsWriteSummary = os.Getenv("SHEETS_WRITE_SUMMARY") == "true"
+	config.SheetsSummaryTab = os.Getenv("SHEETS_SUMMARY_TAB")
+	if config.SheetsSummaryTab == "" {
+		config.SheetsSummaryTab = defaultSheetsSummaryTab
+	}
+	config.StravaWebhookVerifyToken = os.Getenv("STRAVA_WEBHOOK_VERIFY_TOKEN")
+	if subscriptionID := os.Getenv("STRAVA_WEBHOOK_SUBSCRIPTION_ID"); subscriptionID != "" {
+		id, err := strconv.Atoi(subscriptionID)
+		if err != nil {
+			return errors.New("Error: `STRAVA_WEBHOOK_SUBSCRIPTION_ID` must be a number")
+		}
+		config.StravaWebhookSubscriptionID = id
+	}
+	config.LiftUploadToken = os.Getenv("LIFT_UPLOAD_TOKEN")
+	config.StravaRedirectUrl = os.Getenv("STRAVA_REDIRECT_URL")
+	if config.StravaRedirectUrl == "" {
+		config.StravaRedirectUrl = defaultStravaRedirectUrl
+	}
+	config.StravaOAuthScopes = os.Getenv("STRAVA_OAUTH_SCOPES")
+	if config.StravaOAuthScopes == "" {
+		config.StravaOAuthScopes = "read,activity:read"
+	}
+	config.StravaFetchConcurrency = 4
+	if concurrency := os.Getenv("STRAVA_FETCH_CONCURRENCY"); concurrency != "" {
+		limit, err := strconv.Atoi(concurrency)
+		if err != nil || limit <= 0 {
+			return errors.New("Error: `STRAVA_FETCH_CONCURRENCY` must be a positive number")
+		}
+		config.StravaFetchConcurrency = limit
+	}
+	config.HTTPClientTimeout = 30 * time.Second
+	if timeout := os.Getenv("HTTP_CLIENT_TIMEOUT_SECONDS"); timeout != "" {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil || seconds <= 0 {
+			return errors.New("Error: `HTTP_CLIENT_TIMEOUT_SECONDS` must be a positive number of seconds")
+		}
+		config.HTTPClientTimeout = time.Duration(seconds) * time.Second
+	}
+	stravaHTTP.httpClient.Timeout = config.HTTPClientTimeout
+	slack.HTTPClient.Timeout = config.HTTPClientTimeout
+	sheets.HTTPClient.Timeout = config.HTTPClientTimeout
+	config.SlackCommandTimeout = 2500 * time.Millisecond
+	if timeout := os.Getenv("SLACK_COMMAND_TIMEOUT_MS"); timeout != "" {
+		millis, err := strconv.Atoi(timeout)
+		if err != nil || millis <= 0 {
+			return errors.New("Error: `SLACK_COMMAND_TIMEOUT_MS` must be a positive number of milliseconds")
+		}
+		config.SlackCommandTimeout = time.Duration(millis) * time.Millisecond
+	}
+	config.StravaSyncIntervalMinutes = 15
+	if interval := os.Getenv("STRAVA_SYNC_INTERVAL_MINUTES"); interval != "" {
+		minutes, err := strconv.Atoi(interval)
+		if err != nil || minutes <= 0 {
+			return errors.New("Error: `STRAVA_SYNC_INTERVAL_MINUTES` must be a positive number of minutes")
+		}
+		config.StravaSyncIntervalMinutes = minutes
+	}
 
 	if config.SlackChannelHookUrl == "" {
 		return errors.New("Error: `SLACK_CHANNEL_HOOK_URL` env variable not set")
 	}
+	if config.SlackSigningSecret == "" {
+		return errors.New("Error: `SLACK_SIGNING_SECRET` env variable not set")
+	}
 	if config.StravaAPIClientID == "" {
 		return errors.New("Error: `STRAVA_API_CLIENT_ID` env variable not set")
 	}
@@ -242,6 +471,11 @@ func Init() error {
 
 	config.GoogleCloudSavedTokenPath = config.NonVolatileStorageDir + "/gc-token.json"
 
+	if err := LoadChallenges(); err != nil {
+		fmt.Println("Failed to load challenge definitions: " + err.Error())
+		return err
+	}
+
 	APIClientConfig.ClientID = config.StravaAPIClientID
 	APIClientConfig.ClientSecret = config.StravaAPIClientSecret
 	APIClientConfig.TokenEndpoint = config.StravaAPITokenEndpoint
@@ -258,16 +492,57 @@ func Init() error {
 		fmt.Println("No strava user's file found. Add users to create it")
 	}
 
+	if config.ActivityRulesFilePath == "" {
+		config.ActivityRulesFilePath = config.NonVolatileStorageDir + "/" + activityRulesFileName
+	}
+	activityRules = NewActivityRuleSet(config.ActivityRulesFilePath)
+	if config.SheetsLayoutFilePath == "" {
+		config.SheetsLayoutFilePath = config.NonVolatileStorageDir + "/" + sheetsLayoutFileName
+	}
+	_, err := sheetsLayout()
+	if err != nil {
+		return errors.New("Failed to read sheets layout file " + config.SheetsLayoutFilePath + ": " + err.Error())
+	}
+
+	if config.AthleteTimezonesFilePath == "" {
+		config.AthleteTimezonesFilePath = config.NonVolatileStorageDir + "/" + athleteTimezonesFileName
+	}
+
+	cache, err := NewActivityCache(config.NonVolatileStorageDir + "/" + activityCacheDirName)
+	if err != nil {
+		fmt.Println("Failed to create activity cache: " + err.Error())
+		return err
+	}
+	activityCache = cache
+
+	liftLog = NewLiftLog(config.NonVolatileStorageDir + "/" + liftLogFileName)
+
+	// Sources that GenerateReport pulls from. Add new ones here
+	RegisterDataSource(StravaDataSource{})
+	RegisterDataSource(SheetsDataSource{})
+	RegisterDataSource(LiftLogDataSource{})
+
 	// Initialize google cloud api stuffs
-	err := sheets.Initialize(config.GoogleCloudCredentialsFilePath,
+	sheetsClient, err = sheets.NewClient(config.GoogleSheetsID,
+		config.GoogleCloudCredentialsFilePath,
 		config.GoogleCloudSavedTokenPath,
-		authCodeInputUrl)
+		authCodeInputUrl,
+		config.SheetsWriteSummary)
 
 	return err
 
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		err := RunCommand(os.Args[1:])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	err := Init()
 	if err != nil {
 		fmt.Println("Initialization failure: " + err.Error())
@@ -289,17 +564,27 @@ func main() {
 			return
 		}
 
-		err := sheets.SetAuthCodeRetrievedFromWeb(code)
+		err := sheetsClient.SetAuthCode(r.Context(), code, query.Get("state"))
 		if err != nil {
 			fmt.Println("Failed to get token from auth code: " + err.Error())
 			http.Error(w, "Failed to exchange auth code for access token. Error!", http.StatusInternalServerError)
+			return
 		}
 		fmt.Fprintf(w, "Token exchange was successful! Thank You! You can close this browser window/tab now")
 	}).Methods("GET")
 
-	rtr.HandleFunc("/api/slack/post-report", func(w http.ResponseWriter, r *http.Request) {
+	// Everything under /api/slack must be signed by slack
+	slackRtr := rtr.PathPrefix("/api/slack").Subrouter()
+	slackRtr.Use(slack.VerifyRequestsMiddleware(config.SlackSigningSecret))
+
+	slackRtr.HandleFunc("/post-report", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
-		report := "*    Requested Report!* \n\n" + GenerateFormattedReport()
+		challenge, err := challengeFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report := "*    Requested Report!* \n\n" + GenerateFormattedReportForChallenge(r.Context(), challenge)
 
 		// reqStruct := struct {
 		// 	Text string `json:"text"`
@@ -309,32 +594,77 @@ func main() {
 		fmt.Fprintln(w, report)
 	})
 
-	rtr.HandleFunc("/api/slack/norm-cmd", func(w http.ResponseWriter, r *http.Request) {
+	slackRtr.HandleFunc("/norm-cmd", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
-		r.ParseForm()
-		fmt.Println("request.Form::")
-		for key, value := range r.Form {
-			fmt.Printf("Key:%s, Value:%s\n", key, value)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-		fmt.Println("\nrequest.PostForm::")
-		for key, value := range r.PostForm {
-			fmt.Printf("Key:%s, Value:%s\n", key, value)
+		req := SlashCommandRequest{
+			Text:        r.PostForm.Get("text"),
+			UserID:      r.PostForm.Get("user_id"),
+			UserName:    r.PostForm.Get("user_name"),
+			ChannelID:   r.PostForm.Get("channel_id"),
+			ResponseURL: r.PostForm.Get("response_url"),
 		}
-		fmt.Printf("\n text field in FormValue:%s\n", r.FormValue("text"))
-
-		report := "*    Requested Report!* \n\n" + GenerateFormattedReport()
+		fmt.Println("Slash command from " + req.UserName + ": " + req.Text)
 
-		// reqStruct := struct {
-		// 	Text string `json:"text"`
-		// }{Text: report}
-
-		// data, _ := json.Marshal(reqStruct)
-		fmt.Fprintln(w, report)
+		w.Header().Set("Content-Type", "application/json")
+		if req.ResponseURL != "" {
+			// Reports take longer than slack's 3 seconds, so ack now and post the answer when it's ready
+			json.NewEncoder(w).Encode(StartSlashCommand(req))
+			return
+		}
+		// Nowhere to post later, better a partial answer than none
+		ctx, cancel := context.WithTimeout(r.Context(), config.SlackCommandTimeout)
+		defer cancel()
+		json.NewEncoder(w).Encode(RunSlashCommand(ctx, req))
 	})
 
+	rtr.HandleFunc("/api/strava/authorize", func(w http.ResponseWriter, r *http.Request) {
+		state, err := stravaOAuthStates.New()
+		if err != nil {
+			fmt.Println("Failed to generate oauth state: " + err.Error())
+			http.Error(w, "Failed to start strava authorization", http.StatusInternalServerError)
+			return
+		}
+		params := url.Values{
+			"client_id":       {APIClientConfig.ClientID},
+			"response_type":   {"code"},
+			"redirect_uri":    {config.StravaRedirectUrl},
+			"approval_prompt": {"auto"},
+			"scope":           {config.StravaOAuthScopes},
+			"state":           {state},
+		}
+		setStravaOAuthStateCookie(w, state, int(stravaOAuthStateTTL/time.Second))
+		http.Redirect(w, r, stravaAuthorizeEndpoint+"?"+params.Encode(), http.StatusFound)
+	}).Methods("GET")
+
 	rtr.HandleFunc("/api/strava/auth-code", func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query()
-		auth_code := query["code"][0]
+		if authErr := query.Get("error"); authErr != "" {
+			http.Error(w, "Strava authorization was not granted: "+html.EscapeString(authErr), http.StatusBadRequest)
+			return
+		}
+		state := query.Get("state")
+		fromBrowser := stravaOAuthStateFromBrowser(r, state)
+		// Whatever happens the state is used up
+		setStravaOAuthStateCookie(w, "", -1)
+		if !stravaOAuthStates.Consume(state) || !fromBrowser {
+			fmt.Println("Rejecting strava auth-code with a missing, unknown or expired state, or one from another browser")
+			http.Error(w, "Invalid or expired state. Start again from /api/strava/authorize", http.StatusBadRequest)
+			return
+		}
+		auth_code := query.Get("code")
+		if auth_code == "" {
+			http.Error(w, "Missing code in query params", http.StatusBadRequest)
+			return
+		}
+		if !scopeGranted(query.Get("scope"), "activity:read") {
+			http.Error(w, "The activity:read permission is needed to count your activities. Start again from /api/strava/authorize and leave \"View data about your activities\" checked", http.StatusBadRequest)
+			return
+		}
 		formData := url.Values{
 			"client_id":     {APIClientConfig.ClientID},
 			"client_secret": {APIClientConfig.ClientSecret},
@@ -342,31 +672,28 @@ func main() {
 			"grant_type":    {"authorization_code"},
 		}
 		// Send request to strava to authorize user
-		req, err := http.NewRequest(http.MethodPost, APIClientConfig.TokenEndpoint, strings.NewReader(formData.Encode()))
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, APIClientConfig.TokenEndpoint, strings.NewReader(formData.Encode()))
 		if err != nil {
 			fmt.Println("Failed to create request to strava")
 			http.Error(w, "Failed to create request to strava", http.StatusInternalServerError)
 			return
 		}
 		req.Header.Add("Content-Type", "multipart/form-data")
-		respBuf := bytes.Buffer{}
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, body, err := stravaHTTP.Do(req)
 		// Non nil errors means the http request didn't get off the ground. It doesn't mean non 2XX
 		if err != nil {
 			fmt.Println("Failed to send out http request")
 			http.Error(w, "Failed to send out http request", http.StatusInternalServerError)
+			return
 		}
 
-		respBuf.ReadFrom(resp.Body)
-		resp.Body.Close()
-
 		if resp.StatusCode >= 300 {
 			fmt.Println("Request returned http status: " + resp.Status)
 			http.Error(w, "Request to strava returned invalid response: "+resp.Status, resp.StatusCode)
+			return
 		}
 		user := StravaUser{Athlete: StravaAthlete{}}
-		err = json.Unmarshal(respBuf.Bytes(), &user)
+		err = json.Unmarshal(body, &user)
 		if err != nil {
 			fmt.Println("Failed to unmarshal json from strava response into structs. Error: " + err.Error())
 			http.Error(w, "Failed to unmarshal json from strava response into structs. Error: "+err.Error(), http.StatusInternalServerError)
@@ -381,7 +708,7 @@ func main() {
 		}
 
 		fmt.Fprintln(w, "Hello "+user.Athlete.Firstname+", thanks for registering. Your strava data will be included in the challange from now on")
-		userReports, err := GetStravaReport([]StravaUser{user})
+		userReports, err := GetStravaReport(r.Context(), []StravaUser{user})
 		if err != nil {
 			fmt.Println("Failed to Create Report: " + err.Error())
 			http.Error(w, "Failed to create report. Error: "+err.Error(), http.StatusInternalServerError)
@@ -392,27 +719,78 @@ func main() {
 		fmt.Fprintln(w, string(prettyJson[:]))
 	})
 
+	RegisterAPIRoutes(rtr)
+
+	// The leaderboard as json, with each athlete's goal progress
+	rtr.HandleFunc("/api/report", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
+		challenge, err := challengeFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GenerateReportForChallenge(r.Context(), challenge))
+	}).Methods("GET")
+
+	rtr.HandleFunc("/api/strava/webhook", StravaWebhookVerifyHandler).Methods("GET")
+	rtr.HandleFunc("/api/strava/webhook", StravaWebhookEventHandler).Methods("POST")
+
+	rtr.HandleFunc("/api/lifts", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("== Request from: " + html.EscapeString(r.URL.Path))
+		if config.LiftUploadToken == "" {
+			http.Error(w, "Lift uploads are not enabled", http.StatusNotFound)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.LiftUploadToken)) != 1 {
+			http.Error(w, "Invalid upload token", http.StatusUnauthorized)
+			return
+		}
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxLiftUploadBytes))
+		if err != nil {
+			http.Error(w, "Failed to read upload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := UploadLifts(data)
+		if err != nil {
+			fmt.Println("Failed to upload lifts: " + err.Error())
+			http.Error(w, "Failed to upload lifts: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Println("Lift upload: " + result.String())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}).Methods("POST")
+
+	RegisterDashboardRoutes(rtr)
+
 	rtr.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Unmatched request for: " + r.Method + " " + html.EscapeString(r.URL.Path))
-		fmt.Fprintf(w, "Hello, %q", html.EscapeString(r.URL.Path))
+		http.NotFound(w, r)
 	})
 
 	http.Handle("/", rtr)
 
-	newYork, err := time.LoadLocation("America/New_York")
-	if err != nil {
-		fmt.Println("Failed to load timezone America/New_York. Error: " + err.Error())
-		return
-	}
-	s := gocron.NewScheduler(newYork)
+	s := gocron.NewScheduler(CurrentChallenge().Location())
+	// Keep the activity cache fresh so reports don't have to wait on strava
+	s.Every(config.StravaSyncIntervalMinutes).Minutes().Do(DoStravaSync)
 	// Daily at 8:30 pm
 	s.Every(1).Day().At("20:30").Do(DoDailyReport)
+	// Last week's and last month's winners
+	s.Every(1).Monday().At("08:00").Do(DoWeeklyReport)
+	s.Every(1).Month(1).At("08:00").Do(DoMonthlyReport)
+	if config.SheetsWriteSummary {
+		s.Every(1).Hour().Do(PublishSheetSummary)
+	}
 	s.StartAsync()
 
-	userLIftSessions, err := sheets.GetAthleteLiftData(config.GoogleSheetsID, config.GoogleCloudCredentialsFilePath, config.GoogleCloudSavedTokenPath, authCodeInputUrl)
+	layout, _ := sheetsLayout()
+	userLIftSessions, problems, err := readSheetLifts(context.Background(), layout)
 	if err != nil {
 		fmt.Println("Failed to get sheet exercises: " + err.Error())
 	}
+	setSheetProblems(problems)
 	for firstName, liftSessions := range userLIftSessions {
 		fmt.Println(firstName + " has " + strconv.Itoa(len(liftSessions)) + " exercises tracked in the google sheet")
 	}
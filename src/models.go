@@ -62,6 +62,8 @@ type StravaUser struct {
 	RefreshToken string        `json:"refresh_token"`
 	AccessToken  string        `json:"access_token"`
 	Athlete      StravaAthlete `json:"athlete"`
+	// Goals are the athlete's challenge miles goals by challenge year
+	Goals map[int]float32 `json:"goals,omitempty"`
 }
 
 type MetaAthlete struct {
@@ -0,0 +1,55 @@
+package oauthstate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Store hands out random oauth state values and remembers them until they are used or expire.
+// Checking the state on the callback (along with a cookie holding it, so it has to be the same browser)
+// is what stops someone forging an auth-code request (CSRF)
+type Store struct {
+	mutex  sync.Mutex
+	ttl    time.Duration
+	states map[string]time.Time
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl, states: map[string]time.Time{}}
+}
+
+// New creates and remembers a fresh state value
+func (s *Store) New() (string, error) {
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(buf)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	// Clean out anything that expired so abandoned logins don't pile up
+	for existing, expiry := range s.states {
+		if now.After(expiry) {
+			delete(s.states, existing)
+		}
+	}
+	s.states[state] = now.Add(s.ttl)
+	return state, nil
+}
+
+// Consume reports whether the state is one we handed out and hasn't expired. Each state only works once
+func (s *Store) Consume(state string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expiry, ok := s.states[state]
+	if !ok {
+		return false
+	}
+	delete(s.states, state)
+	return time.Now().Before(expiry)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bclouser/miles-challenge/slack"
+)
+
+// Period is a span of calendar time to total activities over. The window's boundaries are wall clock
+// times in the challenge's timezone, and activities are compared by their own local time against them
+type Period struct {
+	// Name is how it's asked for, "day", "week", "month", "7d" or "2022-01-01..2022-01-31"
+	Name string
+	// Title heads up the report
+	Title  string
+	Window ActivityWindow
+}
+
+// startOfDay is midnight at the beginning of t's day in t's location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// weekWindow is the monday-sunday (ISO) week containing now
+func weekWindow(now time.Time) ActivityWindow {
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	start := startOfDay(now).AddDate(0, 0, -daysSinceMonday)
+	return ActivityWindow{Start: start, End: start.AddDate(0, 0, 7)}
+}
+
+// monthWindow is the calendar month containing now
+func monthWindow(now time.Time) ActivityWindow {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return ActivityWindow{Start: start, End: start.AddDate(0, 1, 0)}
+}
+
+func DayPeriod(now time.Time) Period {
+	start := startOfDay(now)
+	return Period{Name: "day", Title: "Today's Report!", Window: ActivityWindow{Start: start, End: start.AddDate(0, 0, 1)}}
+}
+
+func WeekPeriod(now time.Time) Period {
+	return Period{Name: "week", Title: "This Week's Report!", Window: weekWindow(now)}
+}
+
+func MonthPeriod(now time.Time) Period {
+	return Period{Name: "month", Title: "This Month's Report!", Window: monthWindow(now)}
+}
+
+// RollingPeriod is the last days days, today included
+func RollingPeriod(now time.Time, days int) Period {
+	end := startOfDay(now).AddDate(0, 0, 1)
+	return Period{
+		Name:   strconv.Itoa(days) + "d",
+		Title:  "Last " + strconv.Itoa(days) + " Days Report!",
+		Window: ActivityWindow{Start: end.AddDate(0, 0, -days), End: end},
+	}
+}
+
+// RangePeriod is first through last, both days included
+func RangePeriod(first, last time.Time) Period {
+	return Period{
+		Name:   first.Format("2006-01-02") + ".." + last.Format("2006-01-02"),
+		Title:  first.Format("Jan 2") + " - " + last.Format("Jan 2") + " Report!",
+		Window: ActivityWindow{Start: startOfDay(first), End: startOfDay(last).AddDate(0, 0, 1)},
+	}
+}
+
+var rollingPeriodRegex = regexp.MustCompile(`^(\d+)d$`)
+
+// ParsePeriod understands "day" (or "today"), "week", "month", rolling days like "7d" and
+// ranges like "2022-01-01..2022-01-31", all relative to now and in now's location
+func ParsePeriod(text string, now time.Time) (Period, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	switch text {
+	case "day", "today":
+		return DayPeriod(now), nil
+	case "week":
+		return WeekPeriod(now), nil
+	case "month":
+		return MonthPeriod(now), nil
+	}
+	if match := rollingPeriodRegex.FindStringSubmatch(text); match != nil {
+		days, err := strconv.Atoi(match[1])
+		if err != nil || days < 1 || days > 366 {
+			return Period{}, errors.New("Rolling periods can be 1 to 366 days")
+		}
+		return RollingPeriod(now, days), nil
+	}
+	if dates := strings.Split(text, ".."); len(dates) == 2 {
+		first, err := time.ParseInLocation("2006-01-02", dates[0], now.Location())
+		if err != nil {
+			return Period{}, errors.New("Invalid start date `" + dates[0] + "`, use YYYY-MM-DD")
+		}
+		last, err := time.ParseInLocation("2006-01-02", dates[1], now.Location())
+		if err != nil {
+			return Period{}, errors.New("Invalid end date `" + dates[1] + "`, use YYYY-MM-DD")
+		}
+		if last.Before(first) {
+			return Period{}, errors.New("The range ends before it starts")
+		}
+		return RangePeriod(first, last), nil
+	}
+	return Period{}, errors.New("Unknown period `" + text + "`, try day, week, month, 7d or 2022-01-01..2022-01-31")
+}
+
+// Contains goes by the activity's local time, see inLocalWindow
+func (p Period) Contains(activity Activity) bool {
+	return inLocalWindow(p.Window, activity.Date)
+}
+
+// clampTo trims the period to the challenge, ok is false if they don't overlap at all
+func (p Period) clampTo(challenge Challenge) (Period, bool) {
+	if p.Window.Start.Before(challenge.Window().Start) {
+		p.Window.Start = challenge.Window().Start
+	}
+	if p.Window.End.After(challenge.Window().End) {
+		p.Window.End = challenge.Window().End
+	}
+	return p, p.Window.Start.Before(p.Window.End)
+}
+
+// periodReportsFromActivities totals every athlete's activities inside the period, most miles first
+func periodReportsFromActivities(sourceResults [][]AthleteActivities, period Period, now time.Time) []PeriodReport {
+	inPeriod := [][]AthleteActivities{}
+	for _, athletes := range sourceResults {
+		filtered := []AthleteActivities{}
+		for _, athlete := range athletes {
+			activities := []Activity{}
+			for _, activity := range athlete.Activities {
+				if period.Contains(activity) {
+					activities = append(activities, activity)
+				}
+			}
+			athlete.Activities = activities
+			filtered = append(filtered, athlete)
+		}
+		inPeriod = append(inPeriod, filtered)
+	}
+	// Every activity left is inside the period, so the "year to date" is the period total
+	userReports := sortedReports(mergeAthleteActivities(inPeriod, now))
+	reports := []PeriodReport{}
+	for _, userReport := range userReports {
+		reports = append(reports, PeriodReport{
+			AthleteID:            userReport.AthleteID,
+			AthleteFirstName:     userReport.AthleteFirstName,
+			AthleteProfileMedium: userReport.AthleteProfileMedium,
+			Counts:               userReport.YearToDate,
+			Stale:                userReport.Stale,
+			StaleReason:          userReport.StaleReason,
+			Error:                userReport.Error,
+		})
+	}
+	return reports
+}
+
+// GeneratePeriodReport totals every athlete's activities inside the period (never outside of the challenge), most miles first
+func GeneratePeriodReport(ctx context.Context, challenge Challenge, period Period) []PeriodReport {
+	period, ok := period.clampTo(challenge)
+	if !ok {
+		return []PeriodReport{}
+	}
+	sourceResults := FetchFromAllSources(ctx, period.Window)
+	return periodReportsFromActivities(sourceResults, period, challenge.Now())
+}
+
+// How long a scheduled digest may take to put together and send
+const digestTimeout = 10 * time.Minute
+
+// DoWeeklyReport posts last week's winners, it runs monday morning
+func DoWeeklyReport() {
+	now := time.Now().In(CurrentChallenge().Location())
+	period := WeekPeriod(now.AddDate(0, 0, -7))
+	period.Title = ":calendar: Last Week's Report!"
+	sendPeriodDigest(period, "last week")
+}
+
+// DoMonthlyReport posts last month's winners, it runs on the first of the month
+func DoMonthlyReport() {
+	now := time.Now().In(CurrentChallenge().Location())
+	period := MonthPeriod(now.AddDate(0, -1, 0))
+	period.Title = ":calendar: Last Month's Report!"
+	sendPeriodDigest(period, "last month")
+}
+
+// sendPeriodDigest posts the period's winners. The period belongs to the challenge it started in, so last
+// month's digest on Jan 1 is December's in last year's challenge
+func sendPeriodDigest(period Period, when string) {
+	challenge, err := ChallengeForYear(period.Window.Start.Year())
+	if err != nil {
+		fmt.Println("Failed to find the challenge for the digest for " + when + ". Error: " + err.Error())
+		return
+	}
+	if _, ok := period.clampTo(challenge); !ok {
+		fmt.Println("Skipping the digest for " + when + ", it's outside of the challenge")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), digestTimeout)
+	defer cancel()
+	SyncAllStravaUsers(ctx)
+	reports := GeneratePeriodReport(ctx, challenge, period)
+	summary := ""
+	if len(reports) > 0 && reports[0].Counts.Total() > 0 {
+		summary = ":trophy: *" + reports[0].AthleteFirstName + "* won " + when + " with *" + floatStr(reports[0].Counts.Total()) + "* challenge miles!\n\n"
+	}
+	msg := slack.Message{
+		Text:   "*    " + period.Title + "* \n\n" + summary + formatPeriodReports(reports),
+		Blocks: renderPeriodBlocks(period.Title, reports),
+	}
+	if summary != "" && len(msg.Blocks) < slack.MaxBlocks {
+		msg.Blocks = append(msg.Blocks[:1], append([]slack.Block{slack.Section(strings.TrimSpace(summary))}, msg.Blocks[1:]...)...)
+	}
+	err = slack.SendMessage(ctx, config.SlackChannelHookUrl, msg)
+	if err != nil {
+		fmt.Println("Failed to send the digest for " + when + " to slack. Error: " + err.Error())
+	}
+}
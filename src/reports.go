@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"reflect"
 	"sort"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/bclouser/miles-challenge/sheets"
@@ -37,10 +40,16 @@ func (a *AthleteCounts) Total() float32 {
 }
 
 type UserReport struct {
-	AthleteID        int           `json:"athlete_id"`
-	AthleteFirstName string        `json:"athlete_firstname"`
-	YearToDate       AthleteCounts `json:"year_to_date"`
-	Day              AthleteCounts `json:"day"`
+	AthleteID            int           `json:"athlete_id"`
+	AthleteFirstName     string        `json:"athlete_firstname"`
+	AthleteProfileMedium string        `json:"athlete_profile_medium,omitempty"`
+	YearToDate           AthleteCounts `json:"year_to_date"`
+	Day                  AthleteCounts `json:"day"`
+	// Goal is nil for athletes without a goal for the challenge
+	Goal        *GoalProgress `json:"goal,omitempty"`
+	Stale       bool          `json:"stale,omitempty"`
+	StaleReason string        `json:"stale_reason,omitempty"`
+	Error       string        `json:"error,omitempty"`
 }
 
 // Does user1 have more total challenge miles than user2
@@ -74,78 +83,78 @@ func numberToPlaceStr(in int) string {
 		/// Yeah yeah yeah, 21st, 22nd, 23rd. We should probably modulo but idgaf
 		return strconv.Itoa(in) + "th"
 	}
-	return ""
 }
-func GetStravaReport(users []StravaUser) ([]UserReport, error) {
-	reports := []UserReport{}
-	now := time.Now()
-	for _, user := range users {
-		freshUser, err := RefreshToken(user, true)
-		if err != nil {
-			fmt.Println("Failed to refresh token. Error: " + err.Error())
-			return reports, err
-		}
-		currentReport := UserReport{AthleteID: freshUser.Athlete.ID, AthleteFirstName: freshUser.Athlete.Firstname}
 
-		// Get User's activity for this year
-		activities, err := GetUserActivitiesForCurrentYear(freshUser.AccessToken)
-		if err != nil {
-			fmt.Println("Failed to get activites for user: " + freshUser.Athlete.Firstname + " error: " + err.Error())
-		}
+// How long the scheduled daily report may take to put together and send
+const dailyReportTimeout = 10 * time.Minute
 
-		totalActivities := len(activities)
-		fmt.Println(strconv.Itoa(totalActivities) + " strava activities posted in the last year for: " + freshUser.Athlete.Firstname)
-		for _, activity := range activities {
-			//fmt.Println("Date: " + activity.StartDateLocal.String() + " Name: " + activity.Name + ", type: " + activity.Type + ", Distance: " + floatStr(metersToMiles(activity.Distance)))
-			// Was this activity today?
-			if now.Year() == activity.StartDateLocal.Year() && now.YearDay() == activity.StartDateLocal.YearDay() {
-				if activity.Type == "Run" {
-					// Truly determining if this is truly a run is more difficult... gotta look for names in titles
-					if strings.Contains(activity.Name, "run") || strings.Contains(activity.Name, "Run") {
-						currentReport.Day.RunMiles += metersToMiles(activity.Distance)
-					} else {
-						currentReport.Day.LiftMiles += metersToMiles(activity.Distance)
-					}
-				} else if activity.Type == "Hike" {
-					currentReport.Day.HikeMiles += metersToMiles(activity.Distance)
-				}
-			}
-			if activity.Type == "Run" {
-				// Truly determining if this is truly a run is more difficult... gotta look for names in titles
-				if strings.Contains(activity.Name, "run") || strings.Contains(activity.Name, "Run") {
-					currentReport.YearToDate.RunMiles += metersToMiles(activity.Distance)
-				} else {
-					currentReport.YearToDate.LiftMiles += metersToMiles(activity.Distance)
-				}
-			} else if activity.Type == "Hike" {
-				currentReport.YearToDate.HikeMiles += metersToMiles(activity.Distance)
-			}
-		}
-		reports = append(reports, currentReport)
+func DoDailyReport() {
+	ctx, cancel := context.WithTimeout(context.Background(), dailyReportTimeout)
+	defer cancel()
+	// Make sure the day's activities are in the cache before we report on them
+	SyncAllStravaUsers(ctx)
+	athleteReports := GenerateReport(ctx)
+	// Send report to slack
+	report := "   :man-running:  *The Daily Report!* :scroll:\n\n" + formatReports(athleteReports)
+	msg := slack.Message{
+		Text:   report,
+		Blocks: renderReportBlocks(":man-running: The Daily Report! :scroll:", athleteReports),
+	}
+	if problems := lastSheetProblems(); len(problems) > 0 {
+		note := strconv.Itoa(len(problems)) + " row(s) of the google sheet couldn't be read, `/norm sheet` for details"
+		msg.Text += "\n" + note
+		msg.Blocks = append(msg.Blocks, slack.Context(slack.MarkdownElement(":warning: "+note)))
+	}
+	err := slack.SendMessage(ctx, config.SlackChannelHookUrl, msg)
+	if err != nil {
+		fmt.Println("Failed to send daily report to slack. Error: " + err.Error())
 	}
-	return reports, nil
 }
 
-func DoDailyReport() {
-	formattedReport := GenerateFormattedReport()
-	// Send report to slack
-	report := "   :man-running:  *The Daily Report!* :scroll:\n\n" + formattedReport
-	slack.SendChannelMessage(config.SlackChannelHookUrl, report)
+func GenerateFormattedReport(ctx context.Context) string {
+	return GenerateFormattedReportForChallenge(ctx, CurrentChallenge())
+}
+
+func GenerateFormattedReportForChallenge(ctx context.Context, challenge Challenge) string {
+	return formatReports(GenerateReportForChallenge(ctx, challenge))
+}
+
+func formatAthleteReport(place int, athlete UserReport) string {
+	return "*    " + numberToPlaceStr(place) + "*    " + athlete.AthleteFirstName + "\n" +
+		"    Miles Run Today:     " + floatStr(athlete.Day.RunMiles) + "\n" +
+		"    Miles Hiked Today:   " + floatStr(athlete.Day.HikeMiles) + "\n" +
+		"    Miles* Lifted Today: " + floatStr(athlete.Day.LiftMiles) + "\n" +
+		"    ---   \n" +
+		"    Miles Run this Year:     " + floatStr(athlete.YearToDate.RunMiles) + "\n" +
+		"    Miles Hiked this Year:   " + floatStr(athlete.YearToDate.HikeMiles) + "\n" +
+		"    Miles* Lifted this Year: " + floatStr(athlete.YearToDate.LiftMiles) + "\n" +
+		"    Total Challenge Miles: *" + floatStr(athlete.YearToDate.Total()) + "*\n" +
+		goalText(athlete.Goal) +
+		staleText(athlete.Stale, athlete.StaleReason, athlete.Error)
+}
+
+func goalText(goal *GoalProgress) string {
+	if goal == nil {
+		return ""
+	}
+	return "    " + formatGoal(*goal) + "\n"
+}
+
+// staleText warns that an athlete's numbers may be behind or missing
+func staleText(stale bool, reason, errText string) string {
+	if errText != "" {
+		return "    _Couldn't get this athlete's numbers: " + errText + "_\n"
+	}
+	if !stale {
+		return ""
+	}
+	return "    _Numbers may be behind: " + reason + "_\n"
 }
 
-func GenerateFormattedReport() string {
-	athleteReports := GenerateReport()
+func formatReports(athleteReports []UserReport) string {
 	formattedReport := ""
 	for i, athlete := range athleteReports {
-		userReport := "*    " + numberToPlaceStr(i+1) + "*    " + athlete.AthleteFirstName + "\n" +
-			"    Miles Run Today:     " + floatStr(athlete.Day.RunMiles) + "\n" +
-			"    Miles Hiked Today:   " + floatStr(athlete.Day.HikeMiles) + "\n" +
-			"    Miles* Lifted Today: " + floatStr(athlete.Day.LiftMiles) + "\n" +
-			"    ---   \n" +
-			"    Miles Run this Year:     " + floatStr(athlete.YearToDate.RunMiles) + "\n" +
-			"    Miles Hiked this Year:   " + floatStr(athlete.YearToDate.HikeMiles) + "\n" +
-			"    Miles* Lifted this Year: " + floatStr(athlete.YearToDate.LiftMiles) + "\n" +
-			"    Total Challenge Miles: *" + floatStr(athlete.YearToDate.Total()) + "*\n"
+		userReport := formatAthleteReport(i+1, athlete)
 
 		// Add trailing line only if there is another user
 		if i+1 != len(athleteReports) {
@@ -157,85 +166,206 @@ func GenerateFormattedReport() string {
 	return formattedReport
 }
 
+// PeriodReport is an athlete's totals over an arbitrary window of time
+type PeriodReport struct {
+	AthleteID            int           `json:"athlete_id"`
+	AthleteFirstName     string        `json:"athlete_firstname"`
+	AthleteProfileMedium string        `json:"athlete_profile_medium,omitempty"`
+	Counts               AthleteCounts `json:"counts"`
+	Stale                bool          `json:"stale,omitempty"`
+	StaleReason          string        `json:"stale_reason,omitempty"`
+	Error                string        `json:"error,omitempty"`
+}
+
+func formatPeriodReports(reports []PeriodReport) string {
+	formattedReport := ""
+	for i, athlete := range reports {
+		formattedReport += "*    " + numberToPlaceStr(i+1) + "*    " + athlete.AthleteFirstName + "\n" +
+			"    Miles Run:     " + floatStr(athlete.Counts.RunMiles) + "\n" +
+			"    Miles Hiked:   " + floatStr(athlete.Counts.HikeMiles) + "\n" +
+			"    Miles* Lifted: " + floatStr(athlete.Counts.LiftMiles) + "\n" +
+			"    Total Challenge Miles: *" + floatStr(athlete.Counts.Total()) + "*\n" +
+			staleText(athlete.Stale, athlete.StaleReason, athlete.Error)
+		if i+1 != len(reports) {
+			formattedReport += "    -------------------------- \n"
+		}
+	}
+	return formattedReport
+}
+
 func sortedReports(atheleteReportsIn []UserReport) []UserReport {
 	// Sort with greater so that  the first element is "first place"
 	sort.SliceStable(atheleteReportsIn, func(i, j int) bool { return greater(atheleteReportsIn[i].YearToDate, atheleteReportsIn[j].YearToDate) })
 	return atheleteReportsIn
 }
 
-/*
-Ok, so we should really design this to have interfaces called "AthleteDataGetter"
-and we would have one for strava and google sheets and then we can just be like
-stravaDataFetcher.GetAll("ben") and would return a tuple of year, day AthleteCounts{}
-*/
+const sheetsLayoutFileName = "sheets_layout.json"
 
-func GetGoogleSheetReport() ([]UserReport, error) {
-	reports := []UserReport{}
-	// google sheets only track lift data
-	userLiftingReports, err := sheets.GetAthleteLiftData(config.GoogleSheetsID, config.GoogleCloudCredentialsFilePath, config.GoogleCloudSavedTokenPath, authCodeInputUrl)
+var sheetsClient *sheets.Client
+
+// sheetsLayout reads the layout file every time so athletes can be added to the sheet without a restart.
+// No file means the original sheet layout
+func sheetsLayout() (sheets.Layout, error) {
+	layout, err := sheets.ReadLayout(config.SheetsLayoutFilePath)
+	if os.IsNotExist(err) {
+		return sheets.DefaultLayout(), nil
+	}
+	return layout, err
+}
+
+// The rows we couldn't read the last time we read the google sheet
+var sheetProblems = []sheets.RowProblem{}
+var sheetProblemsMutex sync.Mutex
+
+func setSheetProblems(problems []sheets.RowProblem) {
+	for _, problem := range problems {
+		fmt.Println("Google sheet problem. " + problem.String())
+	}
+	sheetProblemsMutex.Lock()
+	defer sheetProblemsMutex.Unlock()
+	sheetProblems = problems
+}
+
+func lastSheetProblems() []sheets.RowProblem {
+	sheetProblemsMutex.Lock()
+	defer sheetProblemsMutex.Unlock()
+	return sheetProblems
+}
+
+// formatSheetProblems lists the problems for slack, or says there aren't any
+func formatSheetProblems(problems []sheets.RowProblem) string {
+	if len(problems) == 0 {
+		return "Every row of the google sheet reads fine :white_check_mark:"
+	}
+	text := strconv.Itoa(len(problems)) + " row(s) of the google sheet need fixing:\n"
+	for _, problem := range problems {
+		text += "    " + problem.String() + "\n"
+	}
+	return text
+}
+
+// The last read of the google sheet. Reports, the api and the dashboard share it so they can't use up
+// google's read quota between them
+var sheetRead struct {
+	mutex    sync.Mutex
+	layout   sheets.Layout
+	at       time.Time
+	sessions map[string][]sheets.LiftSession
+	problems []sheets.RowProblem
+}
+
+// One read of the sheet at a time, so a burst of requests waits for one read instead of all going to
+// google. A channel rather than a mutex so waiting for it can be cancelled
+var sheetReadLock = make(chan struct{}, 1)
+
+// sheetReadMaxAge is how long a read of the sheet is reused, the same as how often strava is synced
+func sheetReadMaxAge() time.Duration {
+	return time.Duration(config.StravaSyncIntervalMinutes) * time.Minute
+}
+
+// readSheetLifts reads the sheet's lift sessions, reusing the last read if it's recent enough and for the same layout
+func readSheetLifts(ctx context.Context, layout sheets.Layout) (map[string][]sheets.LiftSession, []sheets.RowProblem, error) {
+	select {
+	case sheetReadLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	defer func() { <-sheetReadLock }()
+
+	sheetRead.mutex.Lock()
+	if !sheetRead.at.IsZero() && time.Since(sheetRead.at) < sheetReadMaxAge() && reflect.DeepEqual(sheetRead.layout, layout) {
+		sessions, problems := sheetRead.sessions, sheetRead.problems
+		sheetRead.mutex.Unlock()
+		return sessions, problems, nil
+	}
+	sheetRead.mutex.Unlock()
+
+	sessions, problems, err := sheetsClient.GetAthleteLiftData(ctx, layout)
 	if err != nil {
-		return reports, err
-	}
-	now := time.Now()
-	for userName, liftReports := range userLiftingReports {
-		userReport := UserReport{AthleteFirstName: userName}
-		for _, liftReport := range liftReports {
-			userReport.YearToDate.LiftMiles += liftReport.MileConversion
-			userReport.YearToDate.LiftMinutes += liftReport.MinuteDuration
-			// If this activity was today
-			if now.Year() == liftReport.Date.Year() && now.YearDay() == liftReport.Date.YearDay() {
-				userReport.Day.LiftMiles += liftReport.MileConversion
-				userReport.Day.LiftMinutes += liftReport.MinuteDuration
-			}
-		}
-		reports = append(reports, userReport)
+		return sessions, problems, err
 	}
-	return reports, nil
+	sheetRead.mutex.Lock()
+	defer sheetRead.mutex.Unlock()
+	sheetRead.layout, sheetRead.at, sheetRead.sessions, sheetRead.problems = layout, time.Now(), sessions, problems
+	return sessions, problems, nil
+}
+
+// forgetSheetRead makes the next readSheetLifts go to google, for when someone has just fixed the sheet
+func forgetSheetRead() {
+	sheetRead.mutex.Lock()
+	defer sheetRead.mutex.Unlock()
+	sheetRead.at = time.Time{}
 }
 
-func GenerateReport() []UserReport {
-	athleteReports := []UserReport{}
-	// get Strava users from config
+// SheetsDataSource pulls lifting sessions out of the google sheet.
+// The sheet only knows athletes by first name, so we map them onto registered strava users.
+type SheetsDataSource struct{}
+
+func (s SheetsDataSource) Name() string {
+	return "google-sheets"
+}
+
+func (s SheetsDataSource) FetchActivities(ctx context.Context, window ActivityWindow) ([]AthleteActivities, error) {
+	athletes := []AthleteActivities{}
 	users, err := ReadUserCredentials()
 	if err != nil {
-		fmt.Println("Failed to read users in from local credentials file. Error: " + err.Error())
-		return athleteReports
+		return athletes, err
+	}
+	layout, err := sheetsLayout()
+	if err != nil {
+		return athletes, err
 	}
-	athleteReports, err = GetStravaReport(users)
+	// google sheets only track lift data
+	userLiftingReports, problems, err := readSheetLifts(ctx, layout)
 	if err != nil {
-		fmt.Println("Failed to Create daily report. Error: " + err.Error())
-		return athleteReports
+		return athletes, err
 	}
+	setSheetProblems(problems)
+	return liftSessionActivities(s.Name(), userLiftingReports, users, window), nil
+}
 
-	/*
-			type LiftSession struct {
-			Date           time.Time
-			MinuteDuration int
-			MileConversion float32
+// liftSessionActivities turns lift sessions keyed by athlete first name (as the sheet and lift log
+// have them) into activities for the registered strava user with that name
+func liftSessionActivities(source string, sessionsByName map[string][]sheets.LiftSession, users []StravaUser, window ActivityWindow) []AthleteActivities {
+	athletes := []AthleteActivities{}
+	locations := readAthleteTimezones()
+	for userName, liftSessions := range sessionsByName {
+		athleteID, ok := athleteIDForName(userName, users)
+		if !ok {
+			fmt.Println(userName + " from " + source + " doesn't match any registered strava user. Skipping")
+			continue
 		}
-	*/
-	// Get data from google sheets
-	//func GetAthleteLiftData(spreadsheetId, credentialsFilePath, tokenPath, authCodeInputUrl string) (map[string][]LiftSession, error)
-	liftingReports, err := GetGoogleSheetReport()
-	if err != nil {
-		fmt.Println("Failed to retrieve lifting-miles from google sheet. Error: " + err.Error())
-		return sortedReports(athleteReports)
-	}
-
-	// Aggregate and sort data (this is gross)
-	// again we should really have generic interfaces called DataAdder{}
-	// and these functions be like reports = sheetsDataAdder.Add(reports)
-	for i, _ := range athleteReports {
-		// find the matching athlete in the sheets
-		for _, sheetReport := range liftingReports {
-			if athleteReports[i].AthleteFirstName == sheetReport.AthleteFirstName {
-				fmt.Println(athleteReports[i].AthleteFirstName + " has recorded " + floatStr(sheetReport.YearToDate.LiftMiles) + " exercise miles in the google sheet")
-				athleteReports[i].YearToDate.LiftMiles += sheetReport.YearToDate.LiftMiles
-				athleteReports[i].YearToDate.LiftMinutes += sheetReport.YearToDate.LiftMinutes
-				athleteReports[i].Day.LiftMiles += sheetReport.Day.LiftMiles
-				athleteReports[i].Day.LiftMinutes += sheetReport.Day.LiftMinutes
+		athlete := AthleteActivities{AthleteID: athleteID, AthleteFirstName: userName, Location: homeLocation(locations, userName)}
+		// Session dates are just calendar days, they're parsed as midnight UTC so put them in the athlete's timezone
+		loc := athlete.Location
+		if loc == nil {
+			loc = window.Start.Location()
+		}
+		for _, session := range liftSessions {
+			date := wallClockIn(session.Date, loc)
+			if !inLocalWindow(window, date) {
+				continue
 			}
+			athlete.Activities = append(athlete.Activities, Activity{
+				Source:   source,
+				Date:     date,
+				Category: CategoryLift,
+				Miles:    session.MileConversion,
+				Minutes:  session.MinuteDuration,
+			})
 		}
+		athletes = append(athletes, athlete)
 	}
-	return sortedReports(athleteReports)
+	return athletes
+}
+
+func GenerateReport(ctx context.Context) []UserReport {
+	return GenerateReportForChallenge(ctx, CurrentChallenge())
+}
+
+func GenerateReportForChallenge(ctx context.Context, challenge Challenge) []UserReport {
+	sourceResults := FetchFromAllSources(ctx, challenge.Window())
+	reports := sortedReports(mergeAthleteActivities(sourceResults, challenge.Now()))
+	addGoalProgress(reports, challenge)
+	return reports
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bclouser/miles-challenge/sheets"
+)
+
+func TestReadSheetLiftsGivesUpWaitingWithTheContext(t *testing.T) {
+	// Someone else is in the middle of reading the sheet
+	sheetReadLock <- struct{}{}
+	defer func() { <-sheetReadLock }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := readSheetLifts(ctx, sheets.DefaultLayout())
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
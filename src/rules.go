@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const activityRulesFileName = "activity_rules.json"
+
+// RuleMatch describes which strava activities a rule applies to. Empty/nil fields match anything
+type RuleMatch struct {
+	Types       []string `json:"types,omitempty"`
+	WorkoutType *int     `json:"workout_type,omitempty"`
+	NameRegex   string   `json:"name_regex,omitempty"`
+	Trainer     *bool    `json:"trainer,omitempty"`
+	Manual      *bool    `json:"manual,omitempty"`
+	Commute     *bool    `json:"commute,omitempty"`
+	GearID      string   `json:"gear_id,omitempty"`
+
+	nameRegex *regexp.Regexp
+}
+
+// ActivityRule maps matching activities to a challenge category. An empty category means
+// the activity doesn't count. The activity's distance is multiplied by Multiplier (default 1)
+type ActivityRule struct {
+	Name       string    `json:"name"`
+	Match      RuleMatch `json:"match"`
+	Category   string    `json:"category"`
+	Multiplier *float32  `json:"multiplier,omitempty"`
+}
+
+// defaultActivityRules are what we did before there was a rules file. A "Run" only counts
+// as running if it has run in the name, otherwise it was a treadmill-ish lift session
+var defaultActivityRules = []ActivityRule{
+	{Name: "named runs", Match: RuleMatch{Types: []string{"Run"}, NameRegex: "[Rr]un"}, Category: CategoryRun},
+	{Name: "other runs", Match: RuleMatch{Types: []string{"Run"}}, Category: CategoryLift},
+	{Name: "hikes", Match: RuleMatch{Types: []string{"Hike"}}, Category: CategoryHike},
+}
+
+func (m *RuleMatch) compile() error {
+	if m.NameRegex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(m.NameRegex)
+	if err != nil {
+		return err
+	}
+	m.nameRegex = re
+	return nil
+}
+
+func (m *RuleMatch) matches(activity SummaryActivity) bool {
+	if len(m.Types) > 0 {
+		found := false
+		for _, t := range m.Types {
+			if t == activity.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.WorkoutType != nil && *m.WorkoutType != activity.WorkoutType {
+		return false
+	}
+	if m.nameRegex != nil && !m.nameRegex.MatchString(activity.Name) {
+		return false
+	}
+	if m.Trainer != nil && *m.Trainer != activity.Trainer {
+		return false
+	}
+	if m.Manual != nil && *m.Manual != activity.Manual {
+		return false
+	}
+	if m.Commute != nil && *m.Commute != activity.Commute {
+		return false
+	}
+	if m.GearID != "" && m.GearID != activity.GearID {
+		return false
+	}
+	return true
+}
+
+func compileRules(rules []ActivityRule) ([]ActivityRule, error) {
+	compiled := make([]ActivityRule, len(rules))
+	for i, rule := range rules {
+		switch rule.Category {
+		case "", CategoryRun, CategoryHike, CategoryLift:
+		default:
+			return nil, errors.New("Rule " + strconv.Itoa(i) + " (" + rule.Name + ") has unknown category " + rule.Category)
+		}
+		err := rule.Match.compile()
+		if err != nil {
+			return nil, errors.New("Rule " + strconv.Itoa(i) + " (" + rule.Name + ") has invalid name_regex: " + err.Error())
+		}
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+// ClassifyActivity runs the activity through the rules in order and the first match wins.
+// ok is false if no rule matched or the matching rule doesn't count the activity
+func ClassifyActivity(rules []ActivityRule, activity SummaryActivity) (category string, multiplier float32, ok bool) {
+	for i := range rules {
+		if !rules[i].Match.matches(activity) {
+			continue
+		}
+		if rules[i].Category == "" {
+			return "", 0, false
+		}
+		multiplier = 1
+		if rules[i].Multiplier != nil {
+			multiplier = *rules[i].Multiplier
+		}
+		return rules[i].Category, multiplier, true
+	}
+	return "", 0, false
+}
+
+func ReadActivityRules(path string) ([]ActivityRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []ActivityRule{}
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	return compileRules(rules)
+}
+
+// ActivityRuleSet holds the rules from the rules file and reloads them whenever the file changes
+type ActivityRuleSet struct {
+	mutex   sync.RWMutex
+	path    string
+	modTime time.Time
+	rules   []ActivityRule
+}
+
+func NewActivityRuleSet(path string) *ActivityRuleSet {
+	rules, _ := compileRules(defaultActivityRules)
+	ruleSet := &ActivityRuleSet{path: path, rules: rules}
+	ruleSet.reloadIfChanged()
+	return ruleSet
+}
+
+// reloadIfChanged picks up edits to the rules file. A broken file is logged and the
+// previous rules are kept. Deleting the file goes back to the default rules
+func (r *ActivityRuleSet) reloadIfChanged() {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if !r.modTime.IsZero() {
+			fmt.Println("Activity rules file " + r.path + " is gone. Using default rules")
+			r.rules, _ = compileRules(defaultActivityRules)
+			r.modTime = time.Time{}
+		}
+		return
+	}
+
+	r.mutex.RLock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	r.mutex.RUnlock()
+	if unchanged {
+		return
+	}
+
+	rules, err := ReadActivityRules(r.path)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// Record the mod time even on failure so we don't spam the logs on every activity
+	r.modTime = info.ModTime()
+	if err != nil {
+		fmt.Println("Failed to load activity rules from " + r.path + ", keeping previous rules. Error: " + err.Error())
+		return
+	}
+	fmt.Println("Loaded " + strconv.Itoa(len(rules)) + " activity rules from " + r.path)
+	r.rules = rules
+}
+
+// Rules returns the current rules, reloading them from disk first if the file has changed
+func (r *ActivityRuleSet) Rules() []ActivityRule {
+	r.reloadIfChanged()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.rules
+}
+
+var activityRules *ActivityRuleSet
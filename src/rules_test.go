@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadExampleActivity(t *testing.T) SummaryActivity {
+	t.Helper()
+	data, err := ioutil.ReadFile("../test-data/example-activity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := SummaryActivity{}
+	err = json.Unmarshal(data, &activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return activity
+}
+
+// readTestRulesFile writes the rules to a temporary rules file and reads them back like the server would
+func readTestRulesFile(t *testing.T, rulesJSON string) []ActivityRule {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), activityRulesFileName)
+	err := ioutil.WriteFile(path, []byte(rulesJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ReadActivityRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestClassifyActivity(t *testing.T) {
+	defaultRules, err := compileRules(defaultActivityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileRules := readTestRulesFile(t, `[
+		{"name": "commutes don't count", "match": {"commute": true}, "category": ""},
+		{"name": "treadmill walks", "match": {"types": ["Walk"], "trainer": true}, "category": "lift", "multiplier": 0.5},
+		{"name": "named runs", "match": {"types": ["Run"], "name_regex": "[Rr]un"}, "category": "run"}
+	]`)
+	tests := []struct {
+		name           string
+		rules          []ActivityRule
+		edit           func(*SummaryActivity)
+		wantCategory   string
+		wantMultiplier float32
+		wantOK         bool
+	}{
+		{"default rules, lunch run as is", defaultRules, func(a *SummaryActivity) {}, CategoryRun, 1, true},
+		{"default rules, run without run in the name", defaultRules, func(a *SummaryActivity) { a.Name = "Lunch Jog" }, CategoryLift, 1, true},
+		{"default rules, hike", defaultRules, func(a *SummaryActivity) { a.Type = "Hike"; a.Name = "Morning Hike" }, CategoryHike, 1, true},
+		{"default rules, ride doesn't count", defaultRules, func(a *SummaryActivity) { a.Type = "Ride"; a.Name = "Lunch Ride" }, "", 0, false},
+		{"rules file, lunch run as is", fileRules, func(a *SummaryActivity) {}, CategoryRun, 1, true},
+		{"rules file, commute run", fileRules, func(a *SummaryActivity) { a.Commute = true }, "", 0, false},
+		{"rules file, treadmill walk", fileRules, func(a *SummaryActivity) { a.Type = "Walk"; a.Trainer = true }, CategoryLift, 0.5, true},
+		{"rules file, outdoor walk matches nothing", fileRules, func(a *SummaryActivity) { a.Type = "Walk" }, "", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activity := loadExampleActivity(t)
+			test.edit(&activity)
+			category, multiplier, ok := ClassifyActivity(test.rules, activity)
+			if category != test.wantCategory || multiplier != test.wantMultiplier || ok != test.wantOK {
+				t.Errorf("ClassifyActivity() = %q, %v, %v, want %q, %v, %v", category, multiplier, ok, test.wantCategory, test.wantMultiplier, test.wantOK)
+			}
+		})
+	}
+}
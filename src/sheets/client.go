@@ -0,0 +1,242 @@
+package sheets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bclouser/miles-challenge/oauthstate"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Writing needs the full spreadsheets scope, which only gets asked for when writes are enabled
+const readOnlyScope = "https://www.googleapis.com/auth/spreadsheets.readonly"
+const readWriteScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// The auth link is printed in the logs and someone has to go click it, so give them a while
+const authStateTTL = 24 * time.Hour
+
+// HTTPClient is used for every call to google. Callers can override the timeout before the first call
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// withHTTPClient makes the oauth2 library (token refreshes, exchanges) use HTTPClient too
+func withHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, HTTPClient)
+}
+
+// Client talks to one google sheet. Make it once with NewClient, it's safe to share between the
+// scheduler and http handlers
+type Client struct {
+	mutex            sync.Mutex
+	spreadsheetId    string
+	tokenPath        string
+	authCodeInputUrl string
+	writes           bool
+	// oauthConfig is nil when we're using a service account
+	oauthConfig *oauth2.Config
+	authStates  *oauthstate.Store
+	// tokens is nil until someone authorizes us
+	tokens oauth2.TokenSource
+}
+
+// NewClient sets up access to the sheet. A service account key is used as is, otherwise it's an oauth
+// client secrets file and someone has to follow the link printed in the logs the first time.
+// writes asks for write access, a token saved from a read only authorization has to be deleted first
+func NewClient(spreadsheetId, credentialsFilePath, tokenPath, authCodeInputUrl string, writes bool) (*Client, error) {
+	c := &Client{
+		spreadsheetId:    spreadsheetId,
+		tokenPath:        tokenPath,
+		authCodeInputUrl: authCodeInputUrl,
+		writes:           writes,
+		authStates:       oauthstate.NewStore(authStateTTL),
+	}
+	b, err := ioutil.ReadFile(credentialsFilePath)
+	if err != nil {
+		fmt.Println("Unable to read client credentials json file: " + err.Error())
+		return nil, errors.New("Unable to read client credentials json file " + err.Error())
+	}
+	if isServiceAccount(b) {
+		return c, c.initializeServiceAccount(b)
+	}
+
+	// If modifying these scopes, delete your previously saved token.json.
+	c.oauthConfig, err = google.ConfigFromJSON(b, c.scope())
+	if err != nil {
+		fmt.Println("Unable to parse client secret file to config: " + err.Error())
+		return nil, errors.New("Unable to parse client secret file to config: " + err.Error())
+	}
+
+	// The token file stores the access and refresh tokens, and is created when the
+	// authorization flow completes for the first time
+	tok, err := tokenFromFile(tokenPath)
+	if err != nil {
+		c.displayAuthInstructions()
+		fmt.Println("Initialization incomplete until the authorization code is provided via the url: " + authCodeInputUrl)
+		return c, nil
+	}
+	c.tokens = c.persistingTokenSource(tok)
+	_, err = c.tokens.Token()
+	if err != nil {
+		// Could be google being unreachable, or the authorization was revoked. Keep trying the token
+		// we have, but give someone the chance to authorize again
+		fmt.Println("Failed to refresh google token. Error: " + err.Error())
+		c.displayAuthInstructions()
+		return c, nil
+	}
+	fmt.Println("Initialization successful!")
+	return c, nil
+}
+
+func (c *Client) scope() string {
+	if c.writes {
+		return readWriteScope
+	}
+	return readOnlyScope
+}
+
+// isServiceAccount tells a service account key apart from an oauth client secrets file
+func isServiceAccount(credentials []byte) bool {
+	file := struct {
+		Type string `json:"type"`
+	}{}
+	return json.Unmarshal(credentials, &file) == nil && file.Type == "service_account"
+}
+
+func (c *Client) initializeServiceAccount(credentials []byte) error {
+	config, err := google.JWTConfigFromJSON(credentials, c.scope())
+	if err != nil {
+		fmt.Println("Unable to parse service account key: " + err.Error())
+		return errors.New("Unable to parse service account key: " + err.Error())
+	}
+	fmt.Println("Using service account " + config.Email + ", the sheet needs to be shared with it")
+	c.tokens = config.TokenSource(withHTTPClient(context.Background()))
+	_, err = c.tokens.Token()
+	if err != nil {
+		// Could just be google being unreachable, every request tries again
+		fmt.Println("Initialization incomplete, unable to get a token for the service account: " + err.Error())
+		return nil
+	}
+	fmt.Println("Initialization successful!")
+	return nil
+}
+
+// Authorized is false until someone follows the authorization link (never for service accounts)
+func (c *Client) Authorized() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.tokens != nil
+}
+
+func (c *Client) displayAuthInstructions() {
+	state, err := c.authStates.New()
+	if err != nil {
+		fmt.Println("Failed to generate oauth state: " + err.Error())
+		return
+	}
+	authURL := c.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	fmt.Printf("\nGo to the following link in your browser and authorize API access  "+
+		"authorization code: \n%v\n\n", authURL)
+}
+
+// SetAuthCode finishes the authorization started by the link in the logs
+func (c *Client) SetAuthCode(ctx context.Context, authCode, state string) error {
+	if !c.authStates.Consume(state) {
+		return errors.New("Invalid or expired oauth state, use the latest link from the logs")
+	}
+	if c.oauthConfig == nil {
+		return errors.New("No google authorization is pending")
+	}
+	tok, err := c.oauthConfig.Exchange(withHTTPClient(ctx), authCode, oauth2.AccessTypeOffline)
+	if err != nil {
+		fmt.Println("Unable to retrieve token using auth code provided: " + err.Error())
+		return err
+	}
+	err = saveToken(c.tokenPath, tok)
+	if err != nil {
+		fmt.Println("Unable to save access token. Error: " + err.Error())
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tokens = c.persistingTokenSource(tok)
+	return nil
+}
+
+// service is a sheets service for this one call
+func (c *Client) service(ctx context.Context) (*sheets.Service, error) {
+	c.mutex.Lock()
+	tokens := c.tokens
+	c.mutex.Unlock()
+	if tokens == nil {
+		return nil, errors.New("Sheets not authorized yet, follow the link in the logs")
+	}
+	client := oauth2.NewClient(withHTTPClient(ctx), tokens)
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		fmt.Println("Unable to retrieve Sheets client: " + err.Error())
+		return nil, err
+	}
+	return srv, nil
+}
+
+// persistingTokenSource refreshes the token when it expires and saves every new one to the token file
+type persistingTokenSource struct {
+	mutex sync.Mutex
+	base  oauth2.TokenSource
+	path  string
+	last  *oauth2.Token
+}
+
+func (c *Client) persistingTokenSource(tok *oauth2.Token) oauth2.TokenSource {
+	// Refreshes can outlive whatever request triggered them, HTTPClient's timeout still applies
+	base := c.oauthConfig.TokenSource(withHTTPClient(context.Background()), tok)
+	return &persistingTokenSource{base: base, path: c.tokenPath, last: tok}
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tok, err := p.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.AccessToken != p.last.AccessToken {
+		err = saveToken(p.path, tok)
+		if err != nil {
+			fmt.Println("Unable to save refreshed google token. Error: " + err.Error())
+		}
+		p.last = tok
+	}
+	return tok, nil
+}
+
+// Retrieves a token from a local file.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// Saves a token to a file path.
+func saveToken(path string, token *oauth2.Token) error {
+	fmt.Printf("Saving oauth2 token file to: %s\n", path)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(token)
+}
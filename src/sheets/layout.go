@@ -0,0 +1,347 @@
+package sheets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Sheet formats
+const (
+	// FormatBlocks is a block of date, minutes and miles columns per athlete, side by side
+	FormatBlocks = "blocks"
+	// FormatTidy is one row per session with an athlete column
+	FormatTidy = "tidy"
+)
+
+// Layout describes where the lift data lives in the spreadsheet
+type Layout struct {
+	// Tab is the name of the sheet tab to read
+	Tab string `json:"tab"`
+	// HeaderRow is the 1 based row with the column headers, data starts on the row after it
+	HeaderRow int `json:"header_row"`
+	// Format is FormatBlocks or FormatTidy. Left empty it's worked out from the headers
+	Format string `json:"format"`
+	// Blocks is each athlete's columns for FormatBlocks. Left empty they're found from the headers,
+	// every "Date" header starts an athlete's block and their name goes in the row above it
+	Blocks []AthleteBlock `json:"blocks"`
+	// Columns for FormatTidy. Left empty they're found from the headers
+	Columns TidyColumns `json:"columns"`
+	// TotalsRange is where the sheet adds up each athlete's miles itself, like "Q2:R4" (a name column
+	// then a total column), so we can check our numbers against it. Without a tab it's on Tab
+	TotalsRange string `json:"totals_range"`
+}
+
+// AthleteBlock is one athlete's columns, as letters like "F". Minutes and miles default to
+// the two columns after the date
+type AthleteBlock struct {
+	Name          string `json:"name"`
+	DateColumn    string `json:"date_column"`
+	MinutesColumn string `json:"minutes_column"`
+	MilesColumn   string `json:"miles_column"`
+}
+
+// TidyColumns are the column letters of a FormatTidy sheet
+type TidyColumns struct {
+	Athlete string `json:"athlete"`
+	Date    string `json:"date"`
+	Minutes string `json:"minutes"`
+	Miles   string `json:"miles"`
+}
+
+// DefaultLayout is the original challenge sheet: Leben, Ben and Peter side by side on Sheet1
+func DefaultLayout() Layout {
+	return Layout{
+		Tab:       "Sheet1",
+		HeaderRow: 2,
+		Format:    FormatBlocks,
+		Blocks: []AthleteBlock{
+			{Name: "Leben", DateColumn: "A"},
+			{Name: "Ben", DateColumn: "F"},
+			{Name: "Peter", DateColumn: "K"},
+		},
+		TotalsRange: "Q2:R4",
+	}
+}
+
+// ReadLayout reads a json Layout. Without a tab it's DefaultLayout's and without a header_row it's row 1,
+// everything else left out is found from the headers
+func ReadLayout(path string) (Layout, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Layout{}, err
+	}
+	layout := Layout{}
+	err = json.Unmarshal(data, &layout)
+	if err != nil {
+		return Layout{}, err
+	}
+	if layout.Tab == "" {
+		layout.Tab = DefaultLayout().Tab
+	}
+	if layout.HeaderRow == 0 {
+		layout.HeaderRow = 1
+	}
+	return layout, layout.Validate()
+}
+
+func (l Layout) Validate() error {
+	if l.HeaderRow < 1 {
+		return errors.New("Sheet layout header_row must be 1 or more")
+	}
+	if l.Format != "" && l.Format != FormatBlocks && l.Format != FormatTidy {
+		return errors.New("Unknown sheet layout format `" + l.Format + "`, expected `" + FormatBlocks + "` or `" + FormatTidy + "`")
+	}
+	for _, block := range l.Blocks {
+		if block.Name == "" {
+			return errors.New("Every sheet layout block needs an athlete name")
+		}
+		for _, column := range []string{block.DateColumn, block.MinutesColumn, block.MilesColumn} {
+			if _, err := columnIndex(column); column != "" && err != nil {
+				return err
+			}
+		}
+		if block.DateColumn == "" {
+			return errors.New("Sheet layout block for " + block.Name + " needs a date_column")
+		}
+	}
+	for _, column := range []string{l.Columns.Athlete, l.Columns.Date, l.Columns.Minutes, l.Columns.Miles} {
+		if _, err := columnIndex(column); column != "" && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quoteTab(tab string) string {
+	return "'" + strings.ReplaceAll(tab, "'", "''") + "'"
+}
+
+// readRange is the whole tab, so there's no limit on how many rows or athletes it has
+func (l Layout) readRange() string {
+	return quoteTab(l.Tab)
+}
+
+func (l Layout) totalsRange() string {
+	if strings.Contains(l.TotalsRange, "!") {
+		return l.TotalsRange
+	}
+	return quoteTab(l.Tab) + "!" + l.TotalsRange
+}
+
+// columnIndex turns a column letter like "A" or "AB" into a 0 based index
+func columnIndex(column string) (int, error) {
+	column = strings.ToUpper(strings.TrimSpace(column))
+	if column == "" {
+		return 0, errors.New("Empty sheet column")
+	}
+	index := 0
+	for _, c := range column {
+		if c < 'A' || c > 'Z' {
+			return 0, errors.New("Invalid sheet column `" + column + "`")
+		}
+		index = index*26 + int(c-'A'+1)
+	}
+	return index - 1, nil
+}
+
+// columnLetter is the inverse of columnIndex
+func columnLetter(index int) string {
+	letters := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		letters = string(rune('A'+(index-1)%26)) + letters
+	}
+	return letters
+}
+
+// cellString is the trimmed cell, empty when the row is too short to have it
+func cellString(row []interface{}, col int) string {
+	if col < 0 || col >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(row[col]))
+}
+
+// findHeader is the first column whose header is one of names, or -1
+func findHeader(header []interface{}, names ...string) int {
+	for col := range header {
+		for _, name := range names {
+			if strings.EqualFold(cellString(header, col), name) {
+				return col
+			}
+		}
+	}
+	return -1
+}
+
+var minutesHeaders = []string{"minutes", "time", "duration"}
+var milesHeaders = []string{"miles", "mileage"}
+
+// parseRows splits the whole tab into each athlete's sessions according to the layout. Rows that
+// can't be read are left out and returned as problems, the error is for a layout that doesn't fit the sheet
+func (l Layout) parseRows(rows [][]interface{}) (map[string][]LiftSession, []RowProblem, error) {
+	athleteLifts := map[string][]LiftSession{}
+	problems := []RowProblem{}
+	headerIndex := l.HeaderRow - 1
+	if len(rows) <= headerIndex {
+		return athleteLifts, problems, nil
+	}
+	// Sheet row number of data[0]
+	firstRow := l.HeaderRow + 1
+	header := rows[headerIndex]
+	data := rows[headerIndex+1:]
+
+	format := l.Format
+	if format == "" {
+		format = FormatBlocks
+		if findHeader(header, "athlete", "name") >= 0 {
+			format = FormatTidy
+		}
+	}
+
+	if format == FormatTidy {
+		columns, err := l.tidyColumns(header)
+		if err != nil {
+			return athleteLifts, problems, err
+		}
+		for i, row := range data {
+			name := cellString(row, columns.athlete)
+			if name == "" {
+				continue
+			}
+			session, ok, rowProblems := parseLiftSession(name, firstRow+i, row, columns.date, columns.minutes, columns.miles)
+			problems = append(problems, rowProblems...)
+			if ok {
+				athleteLifts[name] = append(athleteLifts[name], session)
+			}
+		}
+		return athleteLifts, problems, nil
+	}
+
+	blocks := l.Blocks
+	if len(blocks) == 0 {
+		nameRow := []interface{}{}
+		if headerIndex > 0 {
+			nameRow = rows[headerIndex-1]
+		}
+		var err error
+		blocks, err = detectBlocks(nameRow, header)
+		if err != nil {
+			return athleteLifts, problems, err
+		}
+	}
+	for _, block := range blocks {
+		dateCol, minutesCol, milesCol, err := block.columns()
+		if err != nil {
+			return athleteLifts, problems, err
+		}
+		sessions, blockProblems := parseAthleteColumns(block.Name, firstRow, data, dateCol, minutesCol, milesCol)
+		athleteLifts[block.Name] = sessions
+		problems = append(problems, blockProblems...)
+	}
+	return athleteLifts, problems, nil
+}
+
+func (b AthleteBlock) columns() (int, int, int, error) {
+	dateCol, err := columnIndex(b.DateColumn)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	minutesCol, milesCol := dateCol+1, dateCol+2
+	if b.MinutesColumn != "" {
+		minutesCol, err = columnIndex(b.MinutesColumn)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if b.MilesColumn != "" {
+		milesCol, err = columnIndex(b.MilesColumn)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return dateCol, minutesCol, milesCol, nil
+}
+
+// detectBlocks finds every "Date" header, the minutes and miles headers after it, and the
+// athlete's name in the row above somewhere over their block
+func detectBlocks(nameRow, header []interface{}) ([]AthleteBlock, error) {
+	dateCols := []int{}
+	for col := range header {
+		if strings.EqualFold(cellString(header, col), "date") {
+			dateCols = append(dateCols, col)
+		}
+	}
+	if len(dateCols) == 0 {
+		return nil, errors.New("Couldn't find any `Date` headers in the sheet")
+	}
+	blocks := []AthleteBlock{}
+	for i, dateCol := range dateCols {
+		end := len(header)
+		if i+1 < len(dateCols) {
+			end = dateCols[i+1]
+		}
+		block := AthleteBlock{DateColumn: columnLetter(dateCol)}
+		for col := dateCol; col < end; col++ {
+			if block.Name == "" {
+				block.Name = cellString(nameRow, col)
+			}
+			if block.MinutesColumn == "" && findHeader(header[col:col+1], minutesHeaders...) == 0 {
+				block.MinutesColumn = columnLetter(col)
+			}
+			if block.MilesColumn == "" && findHeader(header[col:col+1], milesHeaders...) == 0 {
+				block.MilesColumn = columnLetter(col)
+			}
+		}
+		if block.Name == "" {
+			return nil, errors.New("Couldn't find an athlete name above the `Date` header in column " + block.DateColumn)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+type tidyColumnIndexes struct {
+	athlete, date, minutes, miles int
+}
+
+// tidyColumns uses the configured column letters, falling back to looking for the headers
+func (l Layout) tidyColumns(header []interface{}) (tidyColumnIndexes, error) {
+	columns := tidyColumnIndexes{
+		athlete: findHeader(header, "athlete", "name"),
+		date:    findHeader(header, "date"),
+		minutes: findHeader(header, minutesHeaders...),
+		miles:   findHeader(header, milesHeaders...),
+	}
+	configured := []struct {
+		letter string
+		index  *int
+		name   string
+	}{
+		{l.Columns.Athlete, &columns.athlete, "athlete"},
+		{l.Columns.Date, &columns.date, "date"},
+		{l.Columns.Minutes, &columns.minutes, "minutes"},
+		{l.Columns.Miles, &columns.miles, "miles"},
+	}
+	for _, column := range configured {
+		if column.letter != "" {
+			index, err := columnIndex(column.letter)
+			if err != nil {
+				return columns, err
+			}
+			*column.index = index
+		}
+		if *column.index < 0 {
+			return columns, errors.New("Couldn't find the " + column.name + " column in the sheet")
+		}
+	}
+	return columns, nil
+}
+
+// ParseTidyRows reads lift sessions from rows shaped like a FormatTidy sheet with its headers on the
+// first row, for lift logs that don't come from google (csv uploads and the like)
+func ParseTidyRows(rows [][]interface{}) (map[string][]LiftSession, []RowProblem, error) {
+	return Layout{HeaderRow: 1, Format: FormatTidy}.parseRows(rows)
+}
@@ -0,0 +1,105 @@
+package sheets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		nameRow []interface{}
+		header  []interface{}
+		want    []AthleteBlock
+		wantErr bool
+	}{
+		{
+			"the original sheet",
+			[]interface{}{"Leben", "", "", "", "", "Ben"},
+			[]interface{}{"Date", "Time", "Miles", "", "", "Date", "Minutes", "Mileage"},
+			[]AthleteBlock{
+				{Name: "Leben", DateColumn: "A", MinutesColumn: "B", MilesColumn: "C"},
+				{Name: "Ben", DateColumn: "F", MinutesColumn: "G", MilesColumn: "H"},
+			},
+			false,
+		},
+		{
+			"name over the middle of the block and headers out of order",
+			[]interface{}{"", "Peter"},
+			[]interface{}{"date", "miles", "duration"},
+			[]AthleteBlock{{Name: "Peter", DateColumn: "A", MinutesColumn: "C", MilesColumn: "B"}},
+			false,
+		},
+		{
+			"minutes and miles left to the defaults",
+			[]interface{}{"Peter"},
+			[]interface{}{"Date"},
+			[]AthleteBlock{{Name: "Peter", DateColumn: "A"}},
+			false,
+		},
+		{"no date header", []interface{}{"Peter"}, []interface{}{"Day", "Minutes", "Miles"}, nil, true},
+		{"no name above a block", []interface{}{"Peter"}, []interface{}{"Date", "Minutes", "Miles", "Date"}, nil, true},
+		{"no name row", []interface{}{}, []interface{}{"Date", "Minutes", "Miles"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := detectBlocks(test.nameRow, test.header)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTidyColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns TidyColumns
+		header  []interface{}
+		want    tidyColumnIndexes
+		wantErr bool
+	}{
+		{"found from the headers", TidyColumns{}, []interface{}{"Athlete", "Date", "Minutes", "Miles"}, tidyColumnIndexes{0, 1, 2, 3}, false},
+		{"other header names", TidyColumns{}, []interface{}{"Mileage", "Duration", "date", "Name"}, tidyColumnIndexes{3, 2, 1, 0}, false},
+		{"configured letters win", TidyColumns{Miles: "F"}, []interface{}{"Athlete", "Date", "Minutes", "Miles"}, tidyColumnIndexes{0, 1, 2, 5}, false},
+		{"configured letters fill in a missing header", TidyColumns{Date: "E"}, []interface{}{"Athlete", "Minutes", "Miles"}, tidyColumnIndexes{0, 4, 1, 2}, false},
+		{"missing header", TidyColumns{}, []interface{}{"Athlete", "Date", "Minutes"}, tidyColumnIndexes{}, true},
+		{"bad configured letter", TidyColumns{Date: "1"}, []interface{}{"Athlete", "Date", "Minutes", "Miles"}, tidyColumnIndexes{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Layout{Format: FormatTidy, Columns: test.columns}.tidyColumns(test.header)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, test.wantErr)
+			}
+			if !test.wantErr && got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRowsShortRows(t *testing.T) {
+	rows := [][]interface{}{
+		{"Leben", "", "", "Ben"},
+		{"Date", "Minutes", "Miles", "Date", "Minutes", "Miles"},
+		{"1/2/2022", "30", "1"},
+		{},
+		{"", "", "", "1/3/2022", "20", "0.5"},
+		{"13/1/2022"},
+	}
+	sessions, problems, err := Layout{HeaderRow: 2, Format: FormatBlocks}.parseRows(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions["Leben"]) != 1 || len(sessions["Ben"]) != 1 {
+		t.Errorf("sessions = %+v, want one each for Leben and Ben", sessions)
+	}
+	want := []RowProblem{{Athlete: "Leben", Row: 6, Problem: "bad date '13/1/2022'"}}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems = %+v, want %+v", problems, want)
+	}
+}
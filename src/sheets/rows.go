@@ -0,0 +1,124 @@
+package sheets
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RowProblem is a sheet row we couldn't (fully) read
+type RowProblem struct {
+	Athlete string
+	// Row is the sheet's 1 based row number
+	Row     int
+	Problem string
+}
+
+func (p RowProblem) String() string {
+	return p.Athlete + " row " + strconv.Itoa(p.Row) + ": " + p.Problem
+}
+
+// Date formats people have typed into the sheet, the first one is what google shows by default
+var dateFormats = []string{
+	"1/2/2006",
+	"1/2/06",
+	"2006-01-02",
+	"1-2-2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2-Jan-2006",
+	"Mon, Jan 2, 2006",
+}
+
+// Day 0 of google's date serial numbers, which is what we get for unformatted date cells
+var sheetsEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// parseDateCell understands any of dateFormats, and serial day numbers
+func parseDateCell(cell interface{}) (time.Time, bool) {
+	if serial, ok := cell.(float64); ok {
+		return sheetsEpoch.AddDate(0, 0, int(serial)), true
+	}
+	text := strings.TrimSpace(strings.Join(strings.Fields(cellText(cell)), " "))
+	for _, format := range dateFormats {
+		date, err := time.Parse(format, text)
+		if err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseNumberCell handles numbers and text like "1,200" or "45 min"
+func parseNumberCell(cell interface{}) (float64, bool) {
+	if number, ok := cell.(float64); ok {
+		return number, true
+	}
+	text := strings.ReplaceAll(cellText(cell), ",", "")
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+	return number, true
+}
+
+func cellText(cell interface{}) string {
+	if text, ok := cell.(string); ok {
+		return strings.TrimSpace(text)
+	}
+	return cellString([]interface{}{cell}, 0)
+}
+
+func cellAt(row []interface{}, col int) interface{} {
+	if col < 0 || col >= len(row) {
+		return nil
+	}
+	return row[col]
+}
+
+// parseLiftSession reads one session out of the row. Empty rows are skipped quietly, rows with a bad
+// date or miles are skipped with a problem. Missing miles or a bad duration are a problem but the row still counts
+func parseLiftSession(name string, rowNumber int, row []interface{}, dateCol, minutesCol, milesCol int) (LiftSession, bool, []RowProblem) {
+	problems := []RowProblem{}
+	problem := func(text string) {
+		problems = append(problems, RowProblem{Athlete: name, Row: rowNumber, Problem: text})
+	}
+	date := cellString(row, dateCol)
+	minutesText := cellString(row, minutesCol)
+	milesText := cellString(row, milesCol)
+	if date == "" {
+		if minutesText != "" || milesText != "" {
+			problem("missing date")
+		}
+		return LiftSession{}, false, problems
+	}
+	dateTime, ok := parseDateCell(cellAt(row, dateCol))
+	if !ok {
+		problem("bad date '" + date + "'")
+		return LiftSession{}, false, problems
+	}
+
+	miles, ok := parseNumberCell(cellAt(row, milesCol))
+	if milesText == "" {
+		problem("missing miles")
+		miles, ok = 0, true
+	}
+	if !ok || miles < 0 {
+		problem("bad miles '" + milesText + "'")
+		return LiftSession{}, false, problems
+	}
+	minutes, ok := parseNumberCell(cellAt(row, minutesCol))
+	if minutesText != "" && (!ok || minutes < 0) {
+		problem("bad time '" + minutesText + "'")
+		minutes = 0
+	}
+	return LiftSession{
+		Date:           dateTime,
+		MinuteDuration: int(math.Round(minutes)),
+		MileConversion: float32(miles),
+	}, true, problems
+}
@@ -0,0 +1,101 @@
+package sheets
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateCell(t *testing.T) {
+	jan2 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cell interface{}
+		want time.Time
+		ok   bool
+	}{
+		{"1/2/2022", jan2, true},
+		{"1/2/22", jan2, true},
+		{"2022-01-02", jan2, true},
+		{"1-2-2022", jan2, true},
+		{"Jan 2, 2022", jan2, true},
+		{"January 2, 2022", jan2, true},
+		{"2-Jan-2022", jan2, true},
+		{"Sun, Jan 2, 2022", jan2, true},
+		{"  Jan  2,   2022 ", jan2, true},
+		// An unformatted date cell comes back as google's serial day number
+		{float64(44563), jan2, true},
+		{"13/1/2022", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"", time.Time{}, false},
+		{nil, time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseDateCell(test.cell)
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Errorf("parseDateCell(%#v) = %v, %v, want %v, %v", test.cell, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseNumberCell(t *testing.T) {
+	tests := []struct {
+		cell interface{}
+		want float64
+		ok   bool
+	}{
+		{float64(1.5), 1.5, true},
+		{"1.5", 1.5, true},
+		{"1,200", 1200, true},
+		{"45 min", 45, true},
+		{" 3 ", 3, true},
+		{"", 0, false},
+		{nil, 0, false},
+		{"lots", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseNumberCell(test.cell)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseNumberCell(%#v) = %v, %v, want %v, %v", test.cell, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseLiftSession(t *testing.T) {
+	jan2 := time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		row          []interface{}
+		want         LiftSession
+		ok           bool
+		wantProblems []string
+	}{
+		{"text cells", []interface{}{"1/2/2022", "45", "1.5"}, LiftSession{Date: jan2, MinuteDuration: 45, MileConversion: 1.5}, true, nil},
+		{"numeric cells", []interface{}{float64(44563), float64(44.6), float64(2)}, LiftSession{Date: jan2, MinuteDuration: 45, MileConversion: 2}, true, nil},
+		{"empty row", []interface{}{}, LiftSession{}, false, nil},
+		{"blank cells", []interface{}{"", " ", ""}, LiftSession{}, false, nil},
+		{"short row without miles", []interface{}{"1/2/2022", "45"}, LiftSession{Date: jan2, MinuteDuration: 45}, true, []string{"Peter row 47: missing miles"}},
+		{"short row with only a date", []interface{}{"1/2/2022"}, LiftSession{Date: jan2}, true, []string{"Peter row 47: missing miles"}},
+		{"missing date", []interface{}{"", "45", "1.5"}, LiftSession{}, false, []string{"Peter row 47: missing date"}},
+		{"day and month swapped", []interface{}{"13/1/2022", "45", "1.5"}, LiftSession{}, false, []string{"Peter row 47: bad date '13/1/2022'"}},
+		{"bad miles", []interface{}{"1/2/2022", "45", "far"}, LiftSession{}, false, []string{"Peter row 47: bad miles 'far'"}},
+		{"negative miles", []interface{}{"1/2/2022", "45", "-1"}, LiftSession{}, false, []string{"Peter row 47: bad miles '-1'"}},
+		{"bad time still counts", []interface{}{"1/2/2022", "a while", "1.5"}, LiftSession{Date: jan2, MileConversion: 1.5}, true, []string{"Peter row 47: bad time 'a while'"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok, problems := parseLiftSession("Peter", 47, test.row, 0, 1, 2)
+			if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, test.want, test.ok)
+			}
+			gotProblems := []string{}
+			for _, problem := range problems {
+				gotProblems = append(gotProblems, problem.String())
+			}
+			if len(gotProblems) != len(test.wantProblems) || (len(gotProblems) > 0 && !reflect.DeepEqual(gotProblems, test.wantProblems)) {
+				t.Errorf("problems = %q, want %q", gotProblems, test.wantProblems)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package sheets
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// SummaryRow is one athlete's line on the leaderboard tab
+type SummaryRow struct {
+	Athlete         string
+	RunMiles        float32
+	HikeMiles       float32
+	StravaLiftMiles float32
+	SheetLiftMiles  float32
+	// LoggedLiftMiles were uploaded to the lift log rather than put in the sheet
+	LoggedLiftMiles float32
+	TotalMiles      float32
+}
+
+// TotalMismatch is an athlete whose total in the sheet doesn't match the lift miles we counted from it
+type TotalMismatch struct {
+	Athlete      string
+	SheetTotal   float32
+	CountedTotal float32
+}
+
+// Summary is everything written to the summary tab. Rows should already be in leaderboard order
+type Summary struct {
+	Rows       []SummaryRow
+	Mismatches []TotalMismatch
+	Updated    time.Time
+}
+
+func roundMiles(miles float32) float64 {
+	return math.Round(float64(miles)*100) / 100
+}
+
+func (s Summary) values() [][]interface{} {
+	values := [][]interface{}{
+		{"Place", "Athlete", "Run Miles", "Hike Miles", "Strava Lift Miles", "Sheet Lift Miles", "Logged Lift Miles", "Total Miles"},
+	}
+	for i, row := range s.Rows {
+		values = append(values, []interface{}{
+			i + 1, row.Athlete, roundMiles(row.RunMiles), roundMiles(row.HikeMiles),
+			roundMiles(row.StravaLiftMiles), roundMiles(row.SheetLiftMiles), roundMiles(row.LoggedLiftMiles), roundMiles(row.TotalMiles),
+		})
+	}
+	values = append(values, []interface{}{}, []interface{}{"Last updated", s.Updated.Format("2006-01-02 3:04 PM MST")})
+	if len(s.Mismatches) > 0 {
+		values = append(values, []interface{}{}, []interface{}{"Sheet totals that don't match", "Sheet Total", "Counted", "Difference"})
+		for _, mismatch := range s.Mismatches {
+			values = append(values, []interface{}{
+				mismatch.Athlete, roundMiles(mismatch.SheetTotal), roundMiles(mismatch.CountedTotal),
+				roundMiles(mismatch.SheetTotal - mismatch.CountedTotal),
+			})
+		}
+	}
+	return values
+}
+
+// WriteSummary replaces everything on the tab (creating it if needed) with the summary. The client must allow writes
+func (c *Client) WriteSummary(ctx context.Context, tab string, summary Summary) error {
+	if !c.writes {
+		return errors.New("Writing to the google sheet isn't enabled")
+	}
+	srv, err := c.service(ctx)
+	if err != nil {
+		return err
+	}
+	err = ensureTab(ctx, srv, c.spreadsheetId, tab)
+	if err != nil {
+		return err
+	}
+	_, err = srv.Spreadsheets.Values.Clear(c.spreadsheetId, quoteTab(tab), &sheets.ClearValuesRequest{}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	_, err = srv.Spreadsheets.Values.Update(c.spreadsheetId, quoteTab(tab)+"!A1", &sheets.ValueRange{Values: summary.values()}).
+		ValueInputOption("RAW").Context(ctx).Do()
+	return err
+}
+
+// ensureTab adds the tab to the spreadsheet if it isn't there already
+func ensureTab(ctx context.Context, srv *sheets.Service, spreadsheetId, tab string) error {
+	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetId).Fields("sheets.properties.title").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == tab {
+			return nil
+		}
+	}
+	_, err = srv.Spreadsheets.BatchUpdate(spreadsheetId, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: tab}}}},
+	}).Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bclouser/miles-challenge/sheets"
+)
+
+const defaultSheetsSummaryTab = "Leaderboard"
+
+// How far apart the sheet's totals and ours can be before we call it a mismatch, rounding aside
+const sheetTotalTolerance = 0.01
+
+// How long writing the summary may take
+const sheetSummaryTimeout = 5 * time.Minute
+
+// liftMilesFrom adds up the lift miles that came from the source by athlete id
+func liftMilesFrom(source string, sourceResults [][]AthleteActivities) map[int]float32 {
+	byID := map[int]float32{}
+	for _, athletes := range sourceResults {
+		for _, athlete := range athletes {
+			for _, activity := range athlete.Activities {
+				if activity.Source == source && activity.Category == CategoryLift {
+					byID[athlete.AthleteID] += activity.Miles
+				}
+			}
+		}
+	}
+	return byID
+}
+
+// CheckSheetTotals compares the totals the sheet adds up itself against every row we can read from the
+// sheet. Like the sheet's totals that's every row, in the challenge or not, matched to a strava user or not
+func CheckSheetTotals(ctx context.Context) ([]sheets.TotalMismatch, error) {
+	layout, err := sheetsLayout()
+	if err != nil {
+		return nil, err
+	}
+	sheetTotals, err := sheetsClient.GetSheetTotals(ctx, layout)
+	if err != nil || len(sheetTotals) == 0 {
+		return []sheets.TotalMismatch{}, err
+	}
+	sessionsByName, _, err := readSheetLifts(ctx, layout)
+	if err != nil {
+		return nil, err
+	}
+	counted := map[string]float32{}
+	for name, sessions := range sessionsByName {
+		for _, session := range sessions {
+			counted[strings.ToLower(strings.TrimSpace(name))] += session.MileConversion
+		}
+	}
+	mismatches := []sheets.TotalMismatch{}
+	for name, sheetTotal := range sheetTotals {
+		countedTotal := counted[strings.ToLower(strings.TrimSpace(name))]
+		if math.Abs(float64(sheetTotal-countedTotal)) > sheetTotalTolerance {
+			mismatches = append(mismatches, sheets.TotalMismatch{Athlete: name, SheetTotal: sheetTotal, CountedTotal: countedTotal})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Athlete < mismatches[j].Athlete })
+	return mismatches, nil
+}
+
+func formatTotalMismatches(mismatches []sheets.TotalMismatch) string {
+	if len(mismatches) == 0 {
+		return ""
+	}
+	text := "The sheet's own totals don't match what we counted:\n"
+	for _, mismatch := range mismatches {
+		text += "    " + mismatch.Athlete + ": sheet says " + floatStr(mismatch.SheetTotal) + ", we counted " + floatStr(mismatch.CountedTotal) + "\n"
+	}
+	return text
+}
+
+// buildSheetSummary splits each athlete's lifting into what came from strava, the sheet and the lift log
+func buildSheetSummary(reports []UserReport, sourceResults [][]AthleteActivities, mismatches []sheets.TotalMismatch, updated time.Time) sheets.Summary {
+	stravaMiles := liftMilesFrom(StravaDataSource{}.Name(), sourceResults)
+	sheetMiles := liftMilesFrom(SheetsDataSource{}.Name(), sourceResults)
+	loggedMiles := liftMilesFrom(LiftLogDataSource{}.Name(), sourceResults)
+	summary := sheets.Summary{Mismatches: mismatches, Updated: updated}
+	for _, report := range reports {
+		summary.Rows = append(summary.Rows, sheets.SummaryRow{
+			Athlete:         report.AthleteFirstName,
+			RunMiles:        report.YearToDate.RunMiles,
+			HikeMiles:       report.YearToDate.HikeMiles,
+			StravaLiftMiles: stravaMiles[report.AthleteID],
+			SheetLiftMiles:  sheetMiles[report.AthleteID],
+			LoggedLiftMiles: loggedMiles[report.AthleteID],
+			TotalMiles:      report.YearToDate.Total(),
+		})
+	}
+	return summary
+}
+
+// PublishSheetSummary writes the current leaderboard, and any totals that don't match, to the summary tab
+func PublishSheetSummary() {
+	// The summary tab gets wiped every time, never let that be the tab with everyone's lifts
+	layout, err := sheetsLayout()
+	if err != nil || strings.EqualFold(layout.Tab, config.SheetsSummaryTab) {
+		fmt.Println("Not writing the summary to the google sheet, `" + config.SheetsSummaryTab + "` is (or might be) the tab the lifts are read from")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sheetSummaryTimeout)
+	defer cancel()
+	challenge := CurrentChallenge()
+	sourceResults := FetchFromAllSources(ctx, challenge.Window())
+	reports := sortedReports(mergeAthleteActivities(sourceResults, challenge.Now()))
+
+	mismatches, err := CheckSheetTotals(ctx)
+	if err != nil {
+		fmt.Println("Failed to check the google sheet's totals. Error: " + err.Error())
+	}
+	for _, mismatch := range mismatches {
+		fmt.Println("Google sheet total for " + mismatch.Athlete + " is " + floatStr(mismatch.SheetTotal) + " but we counted " + floatStr(mismatch.CountedTotal))
+	}
+
+	summary := buildSheetSummary(reports, sourceResults, mismatches, time.Now().In(challenge.Location()))
+	err = sheetsClient.WriteSummary(ctx, config.SheetsSummaryTab, summary)
+	if err != nil {
+		fmt.Println("Failed to write the summary to the google sheet. Error: " + err.Error())
+		return
+	}
+	fmt.Println("Wrote the leaderboard to the `" + config.SheetsSummaryTab + "` tab of the google sheet")
+}
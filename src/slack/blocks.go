@@ -0,0 +1,82 @@
+package slack
+
+// Just enough of Block Kit to lay out our reports
+// https://api.slack.com/reference/block-kit/blocks
+
+// Slack refuses messages with more blocks than this
+const MaxBlocks = 50
+
+// Text is a Block Kit text object, or a context element when used inside a context block
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Element is an image or text element, used as a section accessory or inside a context block
+type Element struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+type Block struct {
+	Type      string    `json:"type"`
+	Text      *Text     `json:"text,omitempty"`
+	Fields    []Text    `json:"fields,omitempty"`
+	Accessory *Element  `json:"accessory,omitempty"`
+	Elements  []Element `json:"elements,omitempty"`
+}
+
+func Markdown(text string) Text {
+	return Text{Type: "mrkdwn", Text: text}
+}
+
+func PlainText(text string) Text {
+	return Text{Type: "plain_text", Text: text}
+}
+
+func Header(text string) Block {
+	t := PlainText(text)
+	return Block{Type: "header", Text: &t}
+}
+
+func Section(text string) Block {
+	t := Markdown(text)
+	return Block{Type: "section", Text: &t}
+}
+
+// SectionFields lays the fields out in two columns. Slack allows at most 10
+func SectionFields(fields ...string) Block {
+	block := Block{Type: "section"}
+	for _, field := range fields {
+		block.Fields = append(block.Fields, Markdown(field))
+	}
+	return block
+}
+
+func Divider() Block {
+	return Block{Type: "divider"}
+}
+
+func Context(elements ...Element) Block {
+	return Block{Type: "context", Elements: elements}
+}
+
+func MarkdownElement(text string) Element {
+	return Element{Type: "mrkdwn", Text: text}
+}
+
+func ImageElement(imageURL, altText string) Element {
+	return Element{Type: "image", ImageURL: imageURL, AltText: altText}
+}
+
+// WithImage puts a thumbnail on the right hand side of a section
+func (b Block) WithImage(imageURL, altText string) Block {
+	if imageURL == "" {
+		return b
+	}
+	image := ImageElement(imageURL, altText)
+	b.Accessory = &image
+	return b
+}
@@ -2,30 +2,48 @@ package slack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
-func SendChannelMessage(hookUrl, msg string) error {
-	reqStruct := struct {
-		Text string `json:"text"`
-	}{Text: msg}
+// Who gets to see a slash command response
+const (
+	ResponseEphemeral = "ephemeral"
+	ResponseInChannel = "in_channel"
+)
+
+// Message is the json body of a slash command response or an incoming webhook post.
+// When there are Blocks, Text is the plain-text fallback used for notifications
+type Message struct {
+	ResponseType string  `json:"response_type,omitempty"`
+	Text         string  `json:"text"`
+	Blocks       []Block `json:"blocks,omitempty"`
+}
+
+// HTTPClient is used for every call to slack. Callers can override the timeout before the first call
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
 
-	data, err := json.Marshal(reqStruct)
+func SendChannelMessage(ctx context.Context, hookUrl, msg string) error {
+	return SendMessage(ctx, hookUrl, Message{Text: msg})
+}
+
+func SendMessage(ctx context.Context, hookUrl string, msg Message) error {
+	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", hookUrl, bytes.NewReader(data))
+	request, err := http.NewRequestWithContext(ctx, "POST", hookUrl, bytes.NewReader(data))
 	if err != nil {
-		return nil
+		return err
 	}
 	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := HTTPClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,75 @@
+package slack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Slack won't sign a request this old, anything older is a replay
+const maxRequestAge = 5 * time.Minute
+
+// MaxRequestBytes is far more than any slash command or interaction slack sends. The body is read
+// before we know who sent it, so don't let anyone make us read more
+const MaxRequestBytes = 1 << 20
+
+// ComputeSignature is slack's v0 signature of a request body
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func ComputeSignature(signingSecret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks the X-Slack-Signature and X-Slack-Request-Timestamp headers against the body
+func VerifySignature(header http.Header, body []byte, signingSecret string, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return errors.New("Missing slack signature headers")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Invalid slack request timestamp " + timestamp)
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return errors.New("Slack request timestamp is too old, possible replay")
+	}
+	expected := ComputeSignature(signingSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("Slack signature mismatch")
+	}
+	return nil
+}
+
+// VerifyRequestsMiddleware rejects any request that isn't signed by slack with the signing secret.
+// The body is put back so handlers can still parse the form
+func VerifyRequestsMiddleware(signingSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBytes))
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			err = VerifySignature(r.Header, body, signingSecret, time.Now())
+			if err != nil {
+				fmt.Println("Rejecting unverified slack request to " + r.URL.Path + ": " + err.Error())
+				http.Error(w, "Invalid slack signature", http.StatusUnauthorized)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package slack
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// signedHeader signs body the way slack would at the given time
+func signedHeader(secret string, at time.Time, body string) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", timestamp)
+	header.Set("X-Slack-Signature", ComputeSignature(secret, timestamp, []byte(body)))
+	return header
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1531420618, 0)
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&command=%2Fnorm-cmd&text=leaderboard"
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    string
+		wantErr bool
+	}{
+		{"valid signature", signedHeader(testSigningSecret, now, body), body, false},
+		{"valid signature from a few minutes ago", signedHeader(testSigningSecret, now.Add(-4*time.Minute), body), body, false},
+		{"tampered body", signedHeader(testSigningSecret, now, body), strings.Replace(body, "leaderboard", "me", 1), true},
+		{"wrong secret", signedHeader("not-the-secret", now, body), body, true},
+		{"stale timestamp", signedHeader(testSigningSecret, now.Add(-6*time.Minute), body), body, true},
+		{"timestamp from the future", signedHeader(testSigningSecret, now.Add(6*time.Minute), body), body, true},
+		{"missing headers", http.Header{}, body, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifySignature(test.header, []byte(test.body), testSigningSecret, now)
+			if (err != nil) != test.wantErr {
+				t.Errorf("VerifySignature() error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestsMiddleware(t *testing.T) {
+	body := "command=%2Fnorm-cmd&text=week"
+	tests := []struct {
+		name       string
+		header     http.Header
+		body       string
+		wantStatus int
+	}{
+		{"valid signature", signedHeader(testSigningSecret, time.Now(), body), body, http.StatusOK},
+		{"tampered body", signedHeader(testSigningSecret, time.Now(), body), body + "&text=month", http.StatusUnauthorized},
+		{"wrong secret", signedHeader("not-the-secret", time.Now(), body), body, http.StatusUnauthorized},
+		{"stale timestamp", signedHeader(testSigningSecret, time.Now().Add(-10*time.Minute), body), body, http.StatusUnauthorized},
+		{"too big", http.Header{}, strings.Repeat("a", MaxRequestBytes+1), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotBody := ""
+			handler := VerifyRequestsMiddleware(testSigningSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The handler has to be able to read the body again, and parse it as a form
+				data, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotBody = string(data)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/slack/norm-cmd", strings.NewReader(test.body))
+			for key, values := range test.header {
+				req.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, test.wantStatus)
+			}
+			if test.wantStatus == http.StatusOK && gotBody != test.body {
+				t.Errorf("handler read body %q, want %q", gotBody, test.body)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestsMiddlewareRestoresForm(t *testing.T) {
+	body := "command=%2Fnorm-cmd&text=compare+ben+peter"
+	handler := VerifyRequestsMiddleware(testSigningSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if text := r.FormValue("text"); text != "compare ben peter" {
+			t.Errorf("form text = %q", text)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/api/slack/norm-cmd", strings.NewReader(body))
+	req.Header = signedHeader(testSigningSecret, time.Now(), body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/bclouser/miles-challenge/slack"
+)
+
+const slashCommandUsage = "*Usage:* `/norm <command>`\n" +
+	"    `leaderboard` - the full report (the default)\n" +
+	"    `me` - just your numbers\n" +
+	"    `week` - who is winning this week\n" +
+	"    `month` - who is winning this month\n" +
+	"    `period <period>` - who is winning `day`, the last `7d` (any number of days) or `2022-01-01..2022-01-31`\n" +
+	"    `athlete <name>` - one athlete's numbers\n" +
+	"    `compare <name> <name>` - two athletes head to head\n" +
+	"    `goal <miles>` - set your challenge miles goal for this year, `0` removes it\n" +
+	"    `sheet` - rows of the google sheet that couldn't be read, and totals that don't match\n" +
+	"    `help` - this message\n" +
+	"Add `public` or `private` to the end of any command to choose who sees the answer\n"
+
+// SlashCommand is the parsed `text` field of a slack slash command
+type SlashCommand struct {
+	Name string
+	Args []string
+	// Visibility is slack.ResponseInChannel or slack.ResponseEphemeral
+	Visibility string
+}
+
+// SlashCommandRequest is the part of slack's slash command form post we care about
+type SlashCommandRequest struct {
+	Text        string
+	UserID      string
+	UserName    string
+	ChannelID   string
+	ResponseURL string
+}
+
+// How many arguments each command takes
+var slashCommandArgCounts = map[string]int{
+	"leaderboard": 0,
+	"me":          0,
+	"week":        0,
+	"month":       0,
+	"period":      1,
+	"athlete":     1,
+	"compare":     2,
+	"goal":        1,
+	"sheet":       0,
+	"help":        0,
+}
+
+// Commands that only the sender sees unless they ask otherwise, everything else goes to the channel
+var slashCommandPrivateByDefault = map[string]bool{
+	"me":   true,
+	"help": true,
+	"goal": true,
+}
+
+// ParseSlashCommand splits the text into a command and its arguments. No text at all means leaderboard.
+// A trailing `public` or `private` picks the visibility
+func ParseSlashCommand(text string) (SlashCommand, error) {
+	fields := strings.Fields(text)
+	visibility := ""
+	if len(fields) > 0 {
+		switch strings.ToLower(fields[len(fields)-1]) {
+		case "public":
+			visibility = slack.ResponseInChannel
+			fields = fields[:len(fields)-1]
+		case "private":
+			visibility = slack.ResponseEphemeral
+			fields = fields[:len(fields)-1]
+		}
+	}
+	if len(fields) == 0 {
+		fields = []string{"leaderboard"}
+	}
+	cmd := SlashCommand{Name: strings.ToLower(fields[0]), Args: fields[1:], Visibility: visibility}
+	if cmd.Visibility == "" {
+		cmd.Visibility = slack.ResponseInChannel
+		if slashCommandPrivateByDefault[cmd.Name] {
+			cmd.Visibility = slack.ResponseEphemeral
+		}
+	}
+	argCount, ok := slashCommandArgCounts[cmd.Name]
+	if !ok {
+		return cmd, errors.New("Unknown command `" + cmd.Name + "`")
+	}
+	if len(cmd.Args) != argCount {
+		return cmd, errors.New("`" + cmd.Name + "` takes " + strconv.Itoa(argCount) + " argument(s)")
+	}
+	return cmd, nil
+}
+
+func ephemeral(text string) slack.Message {
+	return slack.Message{ResponseType: slack.ResponseEphemeral, Text: text}
+}
+
+func inChannel(text string, blocks []slack.Block) slack.Message {
+	return slack.Message{ResponseType: slack.ResponseInChannel, Text: text, Blocks: blocks}
+}
+
+// RunSlashCommand parses and runs the command. Anything we don't understand gets the usage back, only shown to the sender
+func RunSlashCommand(ctx context.Context, req SlashCommandRequest) slack.Message {
+	cmd, err := ParseSlashCommand(req.Text)
+	if err != nil {
+		return ephemeral(err.Error() + "\n\n" + slashCommandUsage)
+	}
+	msg := runSlashCommand(ctx, req, cmd)
+	// Errors are always just for the sender
+	if msg.ResponseType == slack.ResponseInChannel {
+		msg.ResponseType = cmd.Visibility
+	}
+	return msg
+}
+
+func runSlashCommand(ctx context.Context, req SlashCommandRequest, cmd SlashCommand) slack.Message {
+	switch cmd.Name {
+	case "leaderboard":
+		reports := GenerateReport(ctx)
+		return inChannel("*    Requested Report!* \n\n"+formatReports(reports), renderReportBlocks("Requested Report!", reports))
+	case "me":
+		name := slackUserFirstName(req.UserName)
+		place, report, ok := findAthleteReport(GenerateReport(ctx), name)
+		if !ok {
+			return ephemeral("I couldn't match your slack name `" + req.UserName + "` to a strava athlete. Try `athlete <name>`")
+		}
+		return inChannel(formatAthleteReport(place, report), renderAthleteBlocks("", []int{place}, []UserReport{report}))
+	case "week":
+		return periodCommand(ctx, WeekPeriod(CurrentChallenge().Now()))
+	case "month":
+		return periodCommand(ctx, MonthPeriod(CurrentChallenge().Now()))
+	case "period":
+		period, err := ParsePeriod(cmd.Args[0], CurrentChallenge().Now())
+		if err != nil {
+			return ephemeral(err.Error())
+		}
+		return periodCommand(ctx, period)
+	case "athlete":
+		place, report, ok := findAthleteReport(GenerateReport(ctx), cmd.Args[0])
+		if !ok {
+			return ephemeral("No athlete named `" + cmd.Args[0] + "`")
+		}
+		return inChannel(formatAthleteReport(place, report), renderAthleteBlocks("", []int{place}, []UserReport{report}))
+	case "compare":
+		return compareAthletes(ctx, cmd.Args[0], cmd.Args[1])
+	case "sheet":
+		// Read the sheet again so fixed rows drop off straight away
+		forgetSheetRead()
+		_, err := SheetsDataSource{}.FetchActivities(ctx, CurrentChallenge().Window())
+		if err != nil {
+			return ephemeral("Couldn't read the google sheet: " + err.Error())
+		}
+		text := formatSheetProblems(lastSheetProblems())
+		mismatches, err := CheckSheetTotals(ctx)
+		if err != nil {
+			text += "\nCouldn't check the sheet's totals: " + err.Error()
+		}
+		text += "\n" + formatTotalMismatches(mismatches)
+		return inChannel(text, nil)
+	case "goal":
+		return setGoalCommand(req, cmd.Args[0])
+	case "help":
+		return inChannel(slashCommandUsage, nil)
+	}
+	return ephemeral(slashCommandUsage)
+}
+
+func periodCommand(ctx context.Context, period Period) slack.Message {
+	reports := GeneratePeriodReport(ctx, CurrentChallenge(), period)
+	return inChannel("*    "+period.Title+"* \n\n"+formatPeriodReports(reports), renderPeriodBlocks(period.Title, reports))
+}
+
+// setGoalCommand sets the sender's goal, matching them to an athlete like `me` does
+func setGoalCommand(req SlashCommandRequest, milesText string) slack.Message {
+	miles, err := strconv.ParseFloat(milesText, 32)
+	if err != nil {
+		return ephemeral("Invalid goal `" + milesText + "`, it should be a number of miles")
+	}
+	users, err := ReadUserCredentials()
+	if err != nil {
+		return ephemeral("Couldn't read the registered athletes: " + err.Error())
+	}
+	athleteID, ok := athleteIDForName(slackUserFirstName(req.UserName), users)
+	if !ok {
+		return ephemeral("I couldn't match your slack name `" + req.UserName + "` to a strava athlete")
+	}
+	challenge := CurrentChallenge()
+	err = SetUserGoal(athleteID, challenge.Year, float32(miles))
+	if err != nil {
+		return ephemeral("Couldn't set your goal: " + err.Error())
+	}
+	if miles == 0 {
+		return inChannel("Removed your goal for "+strconv.Itoa(challenge.Year), nil)
+	}
+	return inChannel("Your goal for "+strconv.Itoa(challenge.Year)+" is now "+floatStr(float32(miles))+" challenge miles", nil)
+}
+
+func compareAthletes(ctx context.Context, name1, name2 string) slack.Message {
+	reports := GenerateReport(ctx)
+	place1, report1, ok := findAthleteReport(reports, name1)
+	if !ok {
+		return ephemeral("No athlete named `" + name1 + "`")
+	}
+	place2, report2, ok := findAthleteReport(reports, name2)
+	if !ok {
+		return ephemeral("No athlete named `" + name2 + "`")
+	}
+	// Always put the leader first
+	if place2 < place1 {
+		place1, place2 = place2, place1
+		report1, report2 = report2, report1
+	}
+	lead := report1.YearToDate.Total() - report2.YearToDate.Total()
+	summary := "*" + report1.AthleteFirstName + "* leads *" + report2.AthleteFirstName + "* by *" + floatStr(lead) + "* challenge miles"
+	if lead == 0 {
+		summary = "*" + report1.AthleteFirstName + "* and *" + report2.AthleteFirstName + "* are tied!"
+	}
+	text := summary + "\n\n" +
+		formatAthleteReport(place1, report1) +
+		"    -------------------------- \n" +
+		formatAthleteReport(place2, report2)
+	return inChannel(text, renderAthleteBlocks(summary, []int{place1, place2}, []UserReport{report1, report2}))
+}
+
+// findAthleteReport finds the athlete by first name in the sorted reports, returning their place
+func findAthleteReport(reports []UserReport, name string) (int, UserReport, bool) {
+	for i, report := range reports {
+		if strings.EqualFold(report.AthleteFirstName, name) {
+			return i + 1, report, true
+		}
+	}
+	return 0, UserReport{}, false
+}
+
+// slackUserFirstName guesses a first name from a slack user name like "ben.clouser"
+func slackUserFirstName(userName string) string {
+	fields := strings.FieldsFunc(userName, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-' || r == ' '
+	})
+	if len(fields) == 0 {
+		return userName
+	}
+	return fields[0]
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bclouser/miles-challenge/slack"
+)
+
+// Slack's response_url is good for 30 minutes, don't let a job get anywhere near that
+const slashCommandJobTimeout = 5 * time.Minute
+
+// SlashJobTracker keeps track of the slash commands being worked on in the background, so
+// the same command asked for again while the first is still running doesn't do the work twice
+type SlashJobTracker struct {
+	mutex   sync.Mutex
+	running map[string]time.Time
+}
+
+var slashJobs = &SlashJobTracker{running: map[string]time.Time{}}
+
+// Start claims the job, false means an identical one is already running
+func (t *SlashJobTracker) Start(key string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.running[key]; ok {
+		return false
+	}
+	t.running[key] = time.Now()
+	return true
+}
+
+func (t *SlashJobTracker) Finish(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.running, key)
+}
+
+// slashJobKey is the same for requests that would post the same answer to the same place.
+// Channel messages are shared by everyone in the channel, ephemeral ones only by the sender
+func slashJobKey(req SlashCommandRequest, cmd SlashCommand) string {
+	audience := "channel:" + req.ChannelID
+	if cmd.Visibility == slack.ResponseEphemeral {
+		audience = "user:" + req.UserID
+	}
+	// `me` depends on who asked, even when it goes to the channel
+	if cmd.Name == "me" {
+		audience += ",user:" + req.UserID
+	}
+	return audience + " " + cmd.Visibility + " " + cmd.Name + " " + strings.ToLower(strings.Join(cmd.Args, " "))
+}
+
+// StartSlashCommand returns what to acknowledge slack with straight away, and posts the real
+// answer to the request's response_url when it's ready. Bad commands are answered right away
+func StartSlashCommand(req SlashCommandRequest) slack.Message {
+	cmd, err := ParseSlashCommand(req.Text)
+	if err != nil || cmd.Name == "help" {
+		return RunSlashCommand(context.Background(), req)
+	}
+
+	key := slashJobKey(req, cmd)
+	if !slashJobs.Start(key) {
+		if cmd.Visibility == slack.ResponseInChannel {
+			return ephemeral("Someone already asked for that, it'll show up here shortly")
+		}
+		return ephemeral("Still working on your last `" + cmd.Name + "`, hang tight")
+	}
+	go func() {
+		defer slashJobs.Finish(key)
+		ctx, cancel := context.WithTimeout(context.Background(), slashCommandJobTimeout)
+		defer cancel()
+		msg := RunSlashCommand(ctx, req)
+		err := slack.SendMessage(ctx, req.ResponseURL, msg)
+		if err != nil {
+			fmt.Println("Failed to send slash command response for " + req.UserName + ": " + err.Error())
+		}
+	}()
+	return ephemeral(":hourglass_flowing_sand: Working on `" + cmd.Name + "`...")
+}
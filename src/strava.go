@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One sync per athlete at a time, the scheduler, webhooks and reports could otherwise all be
+// paging through strava for the same athlete. A channel rather than a mutex so waiting for it
+// can be cancelled
+var athleteSyncLocks = map[int]chan struct{}{}
+var athleteSyncLocksMutex sync.Mutex
+
+func lockAthleteSync(ctx context.Context, athleteID int) error {
+	athleteSyncLocksMutex.Lock()
+	lock, ok := athleteSyncLocks[athleteID]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		athleteSyncLocks[athleteID] = lock
+	}
+	athleteSyncLocksMutex.Unlock()
+	select {
+	case lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func unlockAthleteSync(athleteID int) {
+	athleteSyncLocksMutex.Lock()
+	lock := athleteSyncLocks[athleteID]
+	athleteSyncLocksMutex.Unlock()
+	<-lock
+}
+
+// How far before the last sync an incremental sync starts, so activities uploaded late (a watch
+// that synced days later) or whose webhook we missed are still picked up
+const stravaSyncOverlap = 3 * 24 * time.Hour
+
+// SyncStravaUser pulls any activities we don't have yet into the activity cache. If the cache
+// doesn't cover the window we sync all of it, otherwise we only ask strava for activities since
+// a little before the last sync. window is in local time, so athletes east or west of it get their whole days
+func SyncStravaUser(ctx context.Context, user StravaUser, window ActivityWindow) error {
+	athleteID := user.Athlete.ID
+	err := lockAthleteSync(ctx, athleteID)
+	if err != nil {
+		return err
+	}
+	defer unlockAthleteSync(athleteID)
+
+	window = localDayWindow(window)
+	syncWindow := window
+	covered, err := activityCache.Covers(athleteID, window)
+	if err != nil {
+		return err
+	}
+	if covered {
+		syncedTo, err := activityCache.SyncedTo(athleteID)
+		if err != nil {
+			return err
+		}
+		if from := syncedTo.Add(-stravaSyncOverlap); from.After(syncWindow.Start) {
+			syncWindow.Start = from
+		}
+	}
+
+	accessToken, err := stravaClientFor(user).AccessToken(ctx)
+	if err != nil {
+		fmt.Println("Failed to get access token. Error: " + err.Error())
+		return err
+	}
+
+	activities, err := GetUserActivitiesForCurrentYear(ctx, accessToken, syncWindow)
+	if err != nil {
+		fmt.Println("Failed to get activites for user: " + user.Athlete.Firstname + " error: " + err.Error())
+		if isRateLimitError(err) {
+			if markErr := activityCache.MarkThrottled(athleteID, time.Now()); markErr != nil {
+				fmt.Println("Failed to record rate limiting for user: " + user.Athlete.Firstname + " error: " + markErr.Error())
+			}
+		}
+		// Keep what we did get, but don't claim the window is synced
+		if putErr := activityCache.Put(athleteID, activities, ActivityWindow{}); putErr != nil {
+			fmt.Println("Failed to cache activities for user: " + user.Athlete.Firstname + " error: " + putErr.Error())
+		}
+		return err
+	}
+	fmt.Println(strconv.Itoa(len(activities)) + " strava activities synced for: " + user.Athlete.Firstname)
+	return activityCache.Put(athleteID, activities, ActivityWindow{Start: window.Start, End: syncWindow.End})
+}
+
+// forEachUser runs fn for every user with at most config.StravaFetchConcurrency running at once.
+// Users that haven't started when ctx is cancelled get ctx's error
+func forEachUser(ctx context.Context, users []StravaUser, fn func(ctx context.Context, i int, user StravaUser) error) []error {
+	errs := make([]error, len(users))
+	limit := config.StravaFetchConcurrency
+	if limit <= 0 {
+		limit = 1
+	}
+	semaphore := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, user := range users {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, user StravaUser) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = fn(ctx, i, user)
+		}(i, user)
+	}
+	wg.Wait()
+	return errs
+}
+
+// DoStravaSync is the scheduled sync. It gets until the next one is due
+func DoStravaSync() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.StravaSyncIntervalMinutes)*time.Minute)
+	defer cancel()
+	SyncAllStravaUsers(ctx)
+}
+
+// SyncAllStravaUsers brings the cache up to date for every registered user for the current challenge
+func SyncAllStravaUsers(ctx context.Context) {
+	users, err := ReadUserCredentials()
+	if err != nil {
+		fmt.Println("Failed to read users in from local credentials file. Error: " + err.Error())
+		return
+	}
+	window := CurrentChallenge().Window()
+	errs := forEachUser(ctx, users, func(ctx context.Context, i int, user StravaUser) error {
+		return SyncStravaUser(ctx, user, window)
+	})
+	for i, err := range errs {
+		if err != nil {
+			fmt.Println("Failed to sync strava activities for " + users[i].Athlete.Firstname + ". Error: " + err.Error())
+		}
+	}
+}
+
+// cachedStravaAthleteActivities classifies the athlete's cached activities in the window.
+// If we've never synced that far back for the athlete we try to now, and if that fails we
+// report what the cache has and flag it as stale
+func cachedStravaAthleteActivities(ctx context.Context, user StravaUser, window ActivityWindow) (AthleteActivities, error) {
+	athlete := AthleteActivities{
+		AthleteID:            user.Athlete.ID,
+		AthleteFirstName:     user.Athlete.Firstname,
+		AthleteProfileMedium: user.Athlete.ProfileMedium,
+	}
+	covered, err := activityCache.Covers(user.Athlete.ID, localDayWindow(window))
+	if err != nil {
+		return athlete, err
+	}
+	syncErr := error(nil)
+	if !covered {
+		syncErr = SyncStravaUser(ctx, user, window)
+	}
+
+	lastSync, throttledAt, err := activityCache.SyncStatus(user.Athlete.ID)
+	if err != nil {
+		return athlete, err
+	}
+	if syncErr != nil && !isRateLimitError(syncErr) {
+		athlete.Stale = true
+		athlete.StaleReason = "Couldn't sync from strava (" + syncErr.Error() + ")"
+	} else if !throttledAt.IsZero() {
+		athlete.Stale = true
+		athlete.StaleReason = "Strava is rate limiting us"
+	}
+	if athlete.Stale && !lastSync.IsZero() {
+		athlete.StaleReason += ", last synced " + lastSync.In(window.Start.Location()).Format("Jan 2 3:04 PM")
+	}
+
+	// The cache goes by when activities started, we go by the local day they started on
+	activities, err := activityCache.Activities(user.Athlete.ID, localDayWindow(window))
+	if err != nil {
+		return athlete, err
+	}
+	athlete.Location = homeLocation(readAthleteTimezones(), user.Athlete.Firstname)
+	if athlete.Location == nil {
+		athlete.Location = latestActivityLocation(activities)
+	}
+	rules := activityRules.Rules()
+	for _, activity := range activities {
+		date := stravaActivityTime(activity)
+		if !inLocalWindow(window, date) {
+			continue
+		}
+		category, multiplier, ok := ClassifyActivity(rules, activity)
+		if !ok {
+			continue
+		}
+		athlete.Activities = append(athlete.Activities, Activity{
+			Source:   "strava",
+			Date:     date,
+			Category: category,
+			Miles:    metersToMiles(activity.Distance) * multiplier,
+		})
+	}
+	return athlete, nil
+}
+
+// stravaActivityTime is when the activity started in the athlete's own timezone. Strava gives
+// timezones like "(GMT-07:00) America/Denver", when we can't load it we fall back to the local start
+// time strava works out, which has the right wall clock but claims to be UTC
+func stravaActivityTime(activity SummaryActivity) time.Time {
+	loc, ok := stravaActivityLocation(activity)
+	if !ok {
+		return activity.StartDateLocal
+	}
+	return activity.StartDate.In(loc)
+}
+
+// latestActivityLocation is the timezone of the activity that started last, for athletes without a
+// configured home timezone. nil when none of them have a timezone we can load
+func latestActivityLocation(activities []SummaryActivity) *time.Location {
+	var latest *time.Location
+	var latestStart time.Time
+	for _, activity := range activities {
+		loc, ok := stravaActivityLocation(activity)
+		if ok && (latest == nil || activity.StartDate.After(latestStart)) {
+			latest, latestStart = loc, activity.StartDate
+		}
+	}
+	return latest
+}
+
+// stravaActivityLocation loads the IANA zone out of the activity's timezone
+func stravaActivityLocation(activity SummaryActivity) (*time.Location, bool) {
+	name := activity.Timezone
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		name = name[i+1:]
+	}
+	loc, err := time.LoadLocation(name)
+	if name == "" || err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// StravaDataSource reports on every registered strava user out of the activity cache
+type StravaDataSource struct{}
+
+func (s StravaDataSource) Name() string {
+	return "strava"
+}
+
+func (s StravaDataSource) FetchActivities(ctx context.Context, window ActivityWindow) ([]AthleteActivities, error) {
+	// get Strava users from config
+	users, err := ReadUserCredentials()
+	if err != nil {
+		fmt.Println("Failed to read users in from local credentials file. Error: " + err.Error())
+		return nil, err
+	}
+	return getStravaActivities(ctx, users, window), nil
+}
+
+// getStravaActivities gets every user's activities concurrently. An athlete we can't get anything
+// for is still included, with Error set, so one bad athlete doesn't sink the report
+func getStravaActivities(ctx context.Context, users []StravaUser, window ActivityWindow) []AthleteActivities {
+	athletes := make([]AthleteActivities, len(users))
+	errs := forEachUser(ctx, users, func(ctx context.Context, i int, user StravaUser) error {
+		var err error
+		athletes[i], err = cachedStravaAthleteActivities(ctx, user, window)
+		return err
+	})
+	for i, err := range errs {
+		if err != nil {
+			fmt.Println("Failed to get strava activities for " + users[i].Athlete.Firstname + ". Error: " + err.Error())
+			athletes[i].AthleteID = users[i].Athlete.ID
+			athletes[i].AthleteFirstName = users[i].Athlete.Firstname
+			athletes[i].Error = err.Error()
+		}
+	}
+	return athletes
+}
+
+// GetStravaReport syncs the users and reports on them for the current challenge
+func GetStravaReport(ctx context.Context, users []StravaUser) ([]UserReport, error) {
+	challenge := CurrentChallenge()
+	for _, user := range users {
+		err := SyncStravaUser(ctx, user, challenge.Window())
+		if err != nil {
+			return []UserReport{}, err
+		}
+	}
+	athletes := getStravaActivities(ctx, users, challenge.Window())
+	return mergeAthleteActivities([][]AthleteActivities{athletes}, challenge.Now()), nil
+}
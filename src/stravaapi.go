@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many times we retry a 5xx (or a 429 we can wait out) before giving up
+const stravaMaxRetries = 3
+
+// Never sleep longer than this waiting for a rate limit window to reset
+const stravaMaxRateLimitWait = 30 * time.Second
+
+// RateLimitError is returned instead of making a request when strava's budget is used up
+type RateLimitError struct {
+	Window  string // "15 minute" or "daily"
+	Limit   int
+	Usage   int
+	ResetAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return "Strava " + e.Window + " rate limit exhausted (" + strconv.Itoa(e.Usage) + "/" + strconv.Itoa(e.Limit) +
+		"), resets at " + e.ResetAt.Format(time.RFC3339)
+}
+
+func isRateLimitError(err error) bool {
+	var rateLimitErr *RateLimitError
+	return errors.As(err, &rateLimitErr)
+}
+
+// StravaHTTPClient is shared by every call to strava. It keeps track of the 15 minute and
+// daily budgets from strava's X-RateLimit headers so we stop before strava starts refusing us,
+// and retries 429s and 5xx with backoff
+// https://developers.strava.com/docs/rate-limits/
+type StravaHTTPClient struct {
+	mutex      sync.Mutex
+	httpClient *http.Client
+
+	shortLimit int
+	shortUsage int
+	dailyLimit int
+	dailyUsage int
+	updatedAt  time.Time
+}
+
+var stravaHTTP = &StravaHTTPClient{httpClient: &http.Client{Timeout: 30 * time.Second}}
+
+// Strava's 15 minute windows start on the quarter hour, daily ones at midnight UTC
+func nextShortReset(t time.Time) time.Time {
+	return t.UTC().Truncate(15 * time.Minute).Add(15 * time.Minute)
+}
+
+func nextDailyReset(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// parseRateLimitPair parses a "short,daily" header like "600,30000"
+func parseRateLimitPair(header string) (int, int, bool) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	short, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	daily, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return short, daily, true
+}
+
+func (c *StravaHTTPClient) updateFromHeaders(header http.Header, now time.Time) {
+	shortLimit, dailyLimit, ok := parseRateLimitPair(header.Get("X-RateLimit-Limit"))
+	if !ok {
+		return
+	}
+	shortUsage, dailyUsage, ok := parseRateLimitPair(header.Get("X-RateLimit-Usage"))
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.shortLimit, c.dailyLimit = shortLimit, dailyLimit
+	c.shortUsage, c.dailyUsage = shortUsage, dailyUsage
+	c.updatedAt = now
+}
+
+// checkBudget returns a RateLimitError if the last numbers strava gave us say we're out, and
+// that window hasn't reset since
+func (c *StravaHTTPClient) checkBudget(now time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.updatedAt.IsZero() {
+		return nil
+	}
+	if reset := nextDailyReset(c.updatedAt); c.dailyLimit > 0 && c.dailyUsage >= c.dailyLimit && now.Before(reset) {
+		return &RateLimitError{Window: "daily", Limit: c.dailyLimit, Usage: c.dailyUsage, ResetAt: reset}
+	}
+	if reset := nextShortReset(c.updatedAt); c.shortLimit > 0 && c.shortUsage >= c.shortLimit && now.Before(reset) {
+		return &RateLimitError{Window: "15 minute", Limit: c.shortLimit, Usage: c.shortUsage, ResetAt: reset}
+	}
+	return nil
+}
+
+// exhaust marks the 15 minute budget as used up, for 429s without usable headers
+func (c *StravaHTTPClient) exhaust(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.shortLimit == 0 {
+		c.shortLimit = 1
+	}
+	c.shortUsage = c.shortLimit
+	c.updatedAt = now
+}
+
+// Budget is the last known usage and limits, for logging
+func (c *StravaHTTPClient) Budget() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return "15 minute " + strconv.Itoa(c.shortUsage) + "/" + strconv.Itoa(c.shortLimit) +
+		", daily " + strconv.Itoa(c.dailyUsage) + "/" + strconv.Itoa(c.dailyLimit)
+}
+
+// sleepContext sleeps for d, or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Do sends the request and reads the whole response body. Like http.Client.Do a non nil error means
+// we never got a usable response, non 2xx statuses other than 429/5xx are left to the caller
+func (c *StravaHTTPClient) Do(req *http.Request) (*http.Response, []byte, error) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := c.checkBudget(time.Now())
+		if err != nil {
+			rateLimitErr := err.(*RateLimitError)
+			wait := time.Until(rateLimitErr.ResetAt)
+			if attempt >= stravaMaxRetries || wait > stravaMaxRateLimitWait {
+				return nil, nil, err
+			}
+			fmt.Println("Strava rate limit reached, waiting " + wait.Round(time.Second).String() + " for it to reset")
+			err = sleepContext(req.Context(), wait)
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, nil, err
+			}
+			req.Body = body
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		respBuf := bytes.Buffer{}
+		_, err = respBuf.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return resp, nil, err
+		}
+		c.updateFromHeaders(resp.Header, time.Now())
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable {
+			return resp, respBuf.Bytes(), nil
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			fmt.Println("Strava returned 429 Too Many Requests. Budget: " + c.Budget())
+			if c.checkBudget(time.Now()) == nil {
+				c.exhaust(time.Now())
+			}
+			// checkBudget at the top of the loop decides whether to wait or give up
+			continue
+		}
+		if attempt >= stravaMaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, respBuf.Bytes(), nil
+		}
+		fmt.Println("Strava returned " + resp.Status + ", retrying in " + backoff.String())
+		err = sleepContext(req.Context(), backoff)
+		if err != nil {
+			return resp, respBuf.Bytes(), err
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Refresh a little before strava's expiry so a token can't die half way through a sync
+const tokenExpiryMargin = 5 * time.Minute
+
+// StravaClient makes calls to strava on behalf of one user. It implements oauth2.TokenSource
+// and only goes to strava's token endpoint when the access token is about to expire
+type StravaClient struct {
+	mutex sync.Mutex
+	user  StravaUser
+}
+
+// One client per athlete so everyone shares the freshest token
+var stravaClients = map[int]*StravaClient{}
+var stravaClientsMutex sync.Mutex
+
+// stravaClientFor returns the athlete's client. If the user passed in has a newer token than the
+// client (they just re-registered) the client picks it up
+func stravaClientFor(user StravaUser) *StravaClient {
+	stravaClientsMutex.Lock()
+	defer stravaClientsMutex.Unlock()
+	client, ok := stravaClients[user.Athlete.ID]
+	if !ok {
+		client = &StravaClient{user: user}
+		stravaClients[user.Athlete.ID] = client
+		return client
+	}
+	client.mutex.Lock()
+	if user.ExpiresAt.After(client.user.ExpiresAt.Time) {
+		client.user = user
+	}
+	client.mutex.Unlock()
+	return client
+}
+
+// forgetStravaClient drops the client, for athletes that deauthorized us
+func forgetStravaClient(athleteID int) {
+	stravaClientsMutex.Lock()
+	defer stravaClientsMutex.Unlock()
+	delete(stravaClients, athleteID)
+}
+
+func (c *StravaClient) needsRefresh() bool {
+	return c.user.AccessToken == "" || time.Until(c.user.ExpiresAt.Time) < tokenExpiryMargin
+}
+
+// Token implements oauth2.TokenSource
+func (c *StravaClient) Token() (*oauth2.Token, error) {
+	return c.TokenContext(context.Background())
+}
+
+// TokenContext returns the user's token, refreshing (and saving the new tokens) only when needed
+func (c *StravaClient) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.needsRefresh() {
+		if c.user.RefreshToken == "" {
+			return nil, errors.New("No refresh token for athlete " + strconv.Itoa(c.user.Athlete.ID))
+		}
+		fmt.Println("Strava token for " + c.user.Athlete.Firstname + " expires " + c.user.ExpiresAt.String() + ", refreshing")
+		freshUser, err := RefreshToken(ctx, c.user, true)
+		if err != nil {
+			return nil, err
+		}
+		c.user = freshUser
+	}
+	return &oauth2.Token{
+		AccessToken:  c.user.AccessToken,
+		TokenType:    "Bearer",
+		RefreshToken: c.user.RefreshToken,
+		Expiry:       c.user.ExpiresAt.Time,
+	}, nil
+}
+
+// AccessToken is a valid access token for the user
+func (c *StravaClient) AccessToken(ctx context.Context) (string, error) {
+	token, err := c.TokenContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// User is the user with their latest tokens
+func (c *StravaClient) User() StravaUser {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.user
+}
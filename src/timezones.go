@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const athleteTimezonesFileName = "athlete_timezones.json"
+
+// Local times are at most 14 hours either side of UTC, so a day either side of a window catches
+// every activity whose local time falls inside it
+const localTimeMargin = 24 * time.Hour
+
+// readAthleteTimezones reads the athletes' home timezones, first name to IANA zone like
+// {"Peter": "America/Denver"}. Read each call so edits apply straight away. Zones that
+// don't load are logged and left out
+func readAthleteTimezones() map[string]*time.Location {
+	locations := map[string]*time.Location{}
+	data, err := ioutil.ReadFile(config.AthleteTimezonesFilePath)
+	if os.IsNotExist(err) {
+		return locations
+	}
+	if err != nil {
+		fmt.Println("Failed to read athlete timezones. Error: " + err.Error())
+		return locations
+	}
+	zones := map[string]string{}
+	err = json.Unmarshal(data, &zones)
+	if err != nil {
+		fmt.Println("Failed to parse athlete timezones. Error: " + err.Error())
+		return locations
+	}
+	for name, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			fmt.Println("Invalid timezone " + zone + " for " + name + ". Error: " + err.Error())
+			continue
+		}
+		locations[strings.ToLower(strings.TrimSpace(name))] = loc
+	}
+	return locations
+}
+
+// homeLocation is the athlete's configured home timezone, nil if they don't have one
+func homeLocation(locations map[string]*time.Location, name string) *time.Location {
+	return locations[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// sameDay is whether a and b fall on the same calendar day, each in its own location
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// localDayWindow widens the window so a source that goes by absolute time can find everything whose
+// local time is in the window, the caller still has to check the local time with inLocalWindow
+func localDayWindow(window ActivityWindow) ActivityWindow {
+	return ActivityWindow{Start: window.Start.Add(-localTimeMargin), End: window.End.Add(localTimeMargin)}
+}
+
+// inLocalWindow compares the local wall clock time t was in against the window's wall clock times, so
+// a 6am run in Denver counts towards the same day as a 6am run in New York
+func inLocalWindow(window ActivityWindow, t time.Time) bool {
+	return window.Contains(wallClockIn(t, window.Start.Location()))
+}
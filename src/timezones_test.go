@@ -0,0 +1,205 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bclouser/miles-challenge/sheets"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestSameDay(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	losAngeles := mustLoadLocation(t, "America/Los_Angeles")
+	tests := []struct {
+		name string
+		a, b time.Time
+		want bool
+	}{
+		{"same instant same zone", time.Date(2022, 3, 1, 12, 0, 0, 0, newYork), time.Date(2022, 3, 1, 18, 0, 0, 0, newYork), true},
+		{"either side of midnight", time.Date(2022, 3, 1, 23, 59, 0, 0, newYork), time.Date(2022, 3, 2, 0, 0, 0, 0, newYork), false},
+		{"11:30pm in LA is the next day in UTC", time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), time.Date(2022, 3, 2, 7, 30, 0, 0, time.UTC), false},
+		{"each in its own zone", time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), time.Date(2022, 3, 1, 8, 0, 0, 0, newYork), true},
+		{"same day number different year", time.Date(2021, 3, 1, 12, 0, 0, 0, newYork), time.Date(2022, 3, 1, 12, 0, 0, 0, newYork), false},
+		{"spring forward day", time.Date(2022, 3, 13, 1, 30, 0, 0, newYork), time.Date(2022, 3, 13, 23, 30, 0, 0, newYork), true},
+		{"fall back day", time.Date(2022, 11, 6, 0, 30, 0, 0, newYork), time.Date(2022, 11, 6, 23, 30, 0, 0, newYork), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sameDay(test.a, test.b); got != test.want {
+				t.Errorf("sameDay(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func TestInLocalWindow(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	losAngeles := mustLoadLocation(t, "America/Los_Angeles")
+	day := func(year int, month time.Month, d int) ActivityWindow {
+		start := time.Date(year, month, d, 0, 0, 0, 0, newYork)
+		return ActivityWindow{Start: start, End: start.AddDate(0, 0, 1)}
+	}
+	tests := []struct {
+		name   string
+		window ActivityWindow
+		t      time.Time
+		want   bool
+	}{
+		{"11:30pm LA run is on its own day", day(2022, 3, 1), time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), true},
+		{"11:30pm LA run isn't on the next New York day", day(2022, 3, 2), time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), false},
+		{"midnight starts the day", day(2022, 3, 2), time.Date(2022, 3, 2, 0, 0, 0, 0, newYork), true},
+		{"midnight ends the day before", day(2022, 3, 1), time.Date(2022, 3, 2, 0, 0, 0, 0, newYork), false},
+		{"spring forward day is 23 hours", day(2022, 3, 13), time.Date(2022, 3, 13, 23, 59, 0, 0, newYork), true},
+		{"spring forward day morning", day(2022, 3, 13), time.Date(2022, 3, 13, 3, 30, 0, 0, newYork), true},
+		{"fall back day is 25 hours", day(2022, 11, 6), time.Date(2022, 11, 6, 23, 59, 0, 0, newYork), true},
+		{"fall back repeated hour", day(2022, 11, 6), time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC), true},
+		{"sheet date parsed as UTC midnight", day(2022, 3, 1), time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"sheet date isn't the day before", day(2022, 2, 28), time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := inLocalWindow(test.window, test.t); got != test.want {
+				t.Errorf("inLocalWindow(%v, %v) = %v, want %v", test.window, test.t, got, test.want)
+			}
+		})
+	}
+}
+
+func TestStravaActivityTime(t *testing.T) {
+	losAngeles := mustLoadLocation(t, "America/Los_Angeles")
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name     string
+		activity SummaryActivity
+		want     time.Time
+	}{
+		{
+			"11:30pm in LA",
+			SummaryActivity{StartDate: time.Date(2022, 3, 2, 7, 30, 0, 0, time.UTC), StartDateLocal: time.Date(2022, 3, 1, 23, 30, 0, 0, time.UTC), Timezone: "(GMT-08:00) America/Los_Angeles"},
+			time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles),
+		},
+		{
+			"just after spring forward",
+			SummaryActivity{StartDate: time.Date(2022, 3, 13, 7, 30, 0, 0, time.UTC), StartDateLocal: time.Date(2022, 3, 13, 3, 30, 0, 0, time.UTC), Timezone: "(GMT-05:00) America/New_York"},
+			time.Date(2022, 3, 13, 3, 30, 0, 0, newYork),
+		},
+		{
+			"second 1:30am of fall back",
+			SummaryActivity{StartDate: time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC), StartDateLocal: time.Date(2022, 11, 6, 1, 30, 0, 0, time.UTC), Timezone: "(GMT-05:00) America/New_York"},
+			time.Date(2022, 11, 6, 6, 30, 0, 0, time.UTC).In(newYork),
+		},
+		{
+			"unknown timezone falls back to the local start",
+			SummaryActivity{StartDate: time.Date(2022, 3, 2, 7, 30, 0, 0, time.UTC), StartDateLocal: time.Date(2022, 3, 1, 23, 30, 0, 0, time.UTC), Timezone: "(GMT-08:00) Nowhere/Special"},
+			time.Date(2022, 3, 1, 23, 30, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := stravaActivityTime(test.activity)
+			if !got.Equal(test.want) || got.Hour() != test.want.Hour() || got.Day() != test.want.Day() {
+				t.Errorf("stravaActivityTime() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLocalDayWindow(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	window := ActivityWindow{Start: time.Date(2022, 3, 13, 0, 0, 0, 0, newYork), End: time.Date(2022, 3, 14, 0, 0, 0, 0, newYork)}
+	widened := localDayWindow(window)
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		// Local times run from UTC-12 to UTC+14
+		{"first moment of the day at UTC+14", time.Date(2022, 3, 13, 0, 0, 0, 0, time.FixedZone("UTC+14", 14*3600))},
+		{"last moment of the day at UTC-12", time.Date(2022, 3, 13, 23, 59, 59, 0, time.FixedZone("UTC-12", -12*3600))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !inLocalWindow(window, test.t) {
+				t.Fatalf("%v should be in the local window", test.t)
+			}
+			if !widened.Contains(test.t) {
+				t.Errorf("localDayWindow() = %v, doesn't contain %v", widened, test.t)
+			}
+		})
+	}
+}
+
+func TestTodayInHomeTimezone(t *testing.T) {
+	losAngeles := mustLoadLocation(t, "America/Los_Angeles")
+	newYork := mustLoadLocation(t, "America/New_York")
+	run := Activity{Category: CategoryRun, Miles: 3}
+	tests := []struct {
+		name      string
+		home      *time.Location
+		date      time.Time
+		now       time.Time
+		wantToday bool
+	}{
+		{"11:30pm LA run with a UTC server clock", losAngeles, time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), time.Date(2022, 3, 2, 7, 45, 0, 0, time.UTC), true},
+		{"LA run from yesterday", losAngeles, time.Date(2022, 2, 28, 23, 30, 0, 0, losAngeles), time.Date(2022, 3, 2, 7, 45, 0, 0, time.UTC), false},
+		{"no home timezone uses now's", nil, time.Date(2022, 3, 1, 23, 30, 0, 0, losAngeles), time.Date(2022, 3, 2, 7, 45, 0, 0, time.UTC), false},
+		{"spring forward evening", newYork, time.Date(2022, 3, 13, 20, 0, 0, 0, newYork), time.Date(2022, 3, 14, 3, 59, 0, 0, time.UTC), true},
+		{"fall back evening", newYork, time.Date(2022, 11, 6, 20, 0, 0, 0, newYork), time.Date(2022, 11, 7, 4, 59, 0, 0, time.UTC), true},
+		{"fall back, past midnight", newYork, time.Date(2022, 11, 6, 20, 0, 0, 0, newYork), time.Date(2022, 11, 7, 5, 0, 0, 0, time.UTC), false},
+		{"sheet date as UTC midnight", losAngeles, wallClockIn(time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), losAngeles), time.Date(2022, 3, 2, 7, 45, 0, 0, time.UTC), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			activity := run
+			activity.Date = test.date
+			reports := mergeAthleteActivities([][]AthleteActivities{{{AthleteID: 1, Location: test.home, Activities: []Activity{activity}}}}, test.now)
+			gotToday := reports[0].Day.RunMiles > 0
+			if gotToday != test.wantToday {
+				t.Errorf("counted today = %v, want %v", gotToday, test.wantToday)
+			}
+			if reports[0].YearToDate.RunMiles != 3 {
+				t.Errorf("year to date = %v, want 3", reports[0].YearToDate.RunMiles)
+			}
+		})
+	}
+}
+
+func TestLiftSessionDates(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	timezonesFilePath := config.AthleteTimezonesFilePath
+	t.Cleanup(func() { config.AthleteTimezonesFilePath = timezonesFilePath })
+	config.AthleteTimezonesFilePath = filepath.Join(t.TempDir(), athleteTimezonesFileName)
+	users := []StravaUser{{Athlete: StravaAthlete{ID: 1, Firstname: "Peter"}}}
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, newYork)
+	window := ActivityWindow{Start: start, End: start.AddDate(0, 0, 1)}
+	tests := []struct {
+		name string
+		date time.Time
+		want int
+	}{
+		{"the window's day", time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), 1},
+		{"the day before", time.Date(2022, 2, 28, 0, 0, 0, 0, time.UTC), 0},
+		{"the day after", time.Date(2022, 3, 2, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessions := map[string][]sheets.LiftSession{"Peter": {{Date: test.date, MileConversion: 1}}}
+			athletes := liftSessionActivities("sheets", sessions, users, window)
+			if len(athletes) != 1 || len(athletes[0].Activities) != test.want {
+				t.Fatalf("got %+v, want %d activities", athletes, test.want)
+			}
+			if test.want == 1 && !athletes[0].Activities[0].Date.Equal(start) {
+				t.Errorf("date = %v, want %v", athletes[0].Activities[0].Date, start)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long handling a single event in the background may take
+const webhookEventTimeout = time.Minute
+
+// Where the id of our push subscription is kept once create-webhook has made it
+const webhookSubscriptionFileName = "strava_webhook_subscription.json"
+
+const stravaPushSubscriptionsEndpoint = "https://www.strava.com/api/v3/push_subscriptions"
+
+// Events are small, anything bigger isn't from strava
+const maxWebhookEventBytes = 64 << 10
+
+// webhookSubscription is the push subscription strava sends us events for
+type webhookSubscription struct {
+	ID int `json:"id"`
+}
+
+// webhookSubscriptionID is the id events have to carry. STRAVA_WEBHOOK_SUBSCRIPTION_ID wins over the saved
+// subscription, for subscriptions made by hand. 0 means we don't have one and every event is rejected
+func webhookSubscriptionID() int {
+	if config.StravaWebhookSubscriptionID != 0 {
+		return config.StravaWebhookSubscriptionID
+	}
+	data, err := ioutil.ReadFile(config.NonVolatileStorageDir + "/" + webhookSubscriptionFileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Failed to read strava webhook subscription. Error: " + err.Error())
+		}
+		return 0
+	}
+	subscription := webhookSubscription{}
+	err = json.Unmarshal(data, &subscription)
+	if err != nil {
+		fmt.Println("Failed to parse strava webhook subscription. Error: " + err.Error())
+		return 0
+	}
+	return subscription.ID
+}
+
+// CreateWebhookSubscription asks strava to start sending events to callbackUrl and saves the
+// subscription's id. Strava calls StravaWebhookVerifyHandler while this waits, so the server has to be up
+func CreateWebhookSubscription(ctx context.Context, callbackUrl string) (int, error) {
+	if config.StravaWebhookVerifyToken == "" {
+		return 0, errors.New("Error: `STRAVA_WEBHOOK_VERIFY_TOKEN` env variable not set")
+	}
+	formData := url.Values{
+		"client_id":     {config.StravaAPIClientID},
+		"client_secret": {config.StravaAPIClientSecret},
+		"callback_url":  {callbackUrl},
+		"verify_token":  {config.StravaWebhookVerifyToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stravaPushSubscriptionsEndpoint, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	resp, body, err := stravaHTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode >= 300 {
+		return 0, errors.New("Strava returned " + resp.Status + ": " + string(body))
+	}
+	subscription := webhookSubscription{}
+	err = json.Unmarshal(body, &subscription)
+	if err != nil {
+		return 0, err
+	}
+	if subscription.ID == 0 {
+		return 0, errors.New("Strava didn't return a subscription id: " + string(body))
+	}
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return 0, err
+	}
+	return subscription.ID, writeFileAtomic(config.NonVolatileStorageDir+"/"+webhookSubscriptionFileName, data, 0644)
+}
+
+// StravaWebhookEvent is what strava POSTs to us for every push subscription event
+// https://developers.strava.com/docs/webhooks/
+type StravaWebhookEvent struct {
+	AspectType     string                 `json:"aspect_type"` // create, update or delete
+	EventTime      int64                  `json:"event_time"`
+	ObjectID       int64                  `json:"object_id"`
+	ObjectType     string                 `json:"object_type"` // activity or athlete
+	OwnerID        int                    `json:"owner_id"`
+	SubscriptionID int                    `json:"subscription_id"`
+	Updates        map[string]interface{} `json:"updates"`
+}
+
+// StravaWebhookVerifyHandler answers the hub.challenge strava sends when the subscription is created
+func StravaWebhookVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("hub.mode") != "subscribe" {
+		http.Error(w, "Unexpected hub.mode", http.StatusBadRequest)
+		return
+	}
+	if config.StravaWebhookVerifyToken == "" || query.Get("hub.verify_token") != config.StravaWebhookVerifyToken {
+		fmt.Println("Rejecting strava webhook subscription with bad verify token")
+		http.Error(w, "Invalid verify token", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+}
+
+// StravaWebhookEventHandler acks the event straight away (strava wants a response within 2 seconds)
+// and applies it to our local state in the background. Strava doesn't sign events, so anything that
+// isn't for our subscription is turned away and everything else is checked with strava before we act on it
+func StravaWebhookEventHandler(w http.ResponseWriter, r *http.Request) {
+	event := StravaWebhookEvent{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookEventBytes)).Decode(&event)
+	if err != nil {
+		http.Error(w, "Invalid event json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscriptionID := webhookSubscriptionID()
+	if subscriptionID == 0 || event.SubscriptionID != subscriptionID {
+		fmt.Println("Rejecting strava webhook event for subscription " + strconv.Itoa(event.SubscriptionID))
+		http.Error(w, "Unknown subscription", http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	go func() {
+		err := HandleStravaWebhookEvent(event)
+		if err != nil {
+			fmt.Println("Failed to handle strava " + event.ObjectType + " " + event.AspectType + " event for " + strconv.FormatInt(event.ObjectID, 10) + ". Error: " + err.Error())
+		}
+	}()
+}
+
+// HandleStravaWebhookEvent applies the event, as long as it's for one of our registered athletes
+func HandleStravaWebhookEvent(event StravaWebhookEvent) error {
+	user, found, err := FindUserCredentials(event.OwnerID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		fmt.Println("Ignoring " + event.ObjectType + " event for unregistered athlete " + strconv.Itoa(event.OwnerID))
+		return nil
+	}
+	switch event.ObjectType {
+	case "activity":
+		return handleActivityEvent(user, event)
+	case "athlete":
+		// The only athlete event strava sends is a deauthorization
+		if event.AspectType == "update" && fmt.Sprint(event.Updates["authorized"]) == "false" {
+			return deauthorizeAthlete(user)
+		}
+		return nil
+	}
+	return errors.New("Unknown webhook object type " + event.ObjectType)
+}
+
+// handleActivityEvent fetches the activity from strava rather than trusting the event, deletes included.
+// Only an activity strava won't show us anymore comes out of the cache
+func handleActivityEvent(user StravaUser, event StravaWebhookEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookEventTimeout)
+	defer cancel()
+	accessToken, err := stravaClientFor(user).AccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	activity, err := GetStravaActivity(ctx, accessToken, event.ObjectID)
+	if err == ErrStravaNotFound {
+		// Deleted, or made private and we don't have the scope to see it anymore
+		return activityCache.Delete(event.OwnerID, event.ObjectID)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Strava " + event.AspectType + " of activity \"" + activity.Name + "\" for " + user.Athlete.Firstname)
+	return activityCache.Put(event.OwnerID, []SummaryActivity{activity}, ActivityWindow{})
+}
+
+// deauthorizeAthlete forgets everything about an athlete that revoked our access. We only believe
+// the event once strava turns down their refresh token
+func deauthorizeAthlete(user StravaUser) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookEventTimeout)
+	defer cancel()
+	freshUser, err := RefreshToken(ctx, user, true)
+	if err == nil {
+		// Keep the new tokens in case strava rotated the refresh token
+		stravaClientFor(freshUser)
+		fmt.Println("Ignoring deauthorization of " + user.Athlete.Firstname + ", strava still accepts their token")
+		return nil
+	}
+	if err != ErrStravaUnauthorized {
+		return err
+	}
+	athleteID := user.Athlete.ID
+	fmt.Println("Athlete " + strconv.Itoa(athleteID) + " deauthorized the app. Removing them")
+	forgetStravaClient(athleteID)
+	err = RemoveUserCredentials(athleteID)
+	if err != nil {
+		return err
+	}
+	return activityCache.RemoveAthlete(athleteID)
+}